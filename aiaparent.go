@@ -29,15 +29,15 @@ import (
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/pem"
+	//"encoding/pem"
 	//"flag"
 	"io/ioutil"
 	"log"
 	"math/big"
 	//"net"
-	"os"
+	//"os"
 	//"strings"
-	"time"
+	//"time"
 )
 
 //var (
@@ -97,34 +97,34 @@ func getAIAParent() (x509.Certificate, any) {
 		log.Fatalf("Failed to generate private key: %v", err)
 	}
 
-	var privPEM []byte
 	if *grandparentKey != "" {
 		log.Print("Using existing CA private key")
-		privPEM, err = ioutil.ReadFile(*grandparentKey)
+		priv, err = readPrivateKey(*grandparentKey, grandparentKeyPassphrase)
 		if err != nil {
-			log.Fatalf("failed to read private key: %v", err)
-		}
-		privBlock, _ := pem.Decode(privPEM)
-		priv, err = x509.ParsePKCS8PrivateKey(privBlock.Bytes)
-		if err != nil {
-			log.Fatalf("failed to parse private key: %v", err)
+			log.Fatalf("Failed to read private key: %v", err)
 		}
 	}
 
-	var chainPEM []byte
 	if *grandparentChain != "" {
 		log.Print("Using existing CA cert chain")
-		chainPEM, err = ioutil.ReadFile(*grandparentChain)
+		chainCerts, err := readCertChain(*grandparentChain)
 		if err != nil {
 			log.Fatalf("Failed to read cert chain: %v", err)
 		}
-		chainBlock, _ := pem.Decode(chainPEM)
-		parsedCert, err := x509.ParseCertificate(chainBlock.Bytes)
+
+		if *grandparentKey == "" {
+			log.Fatalf("-grandparent-chain requires -grandparent-key")
+		}
+		err = checkKeyMatchesCert(priv, chainCerts[0])
 		if err != nil {
-			log.Fatalf("Failed to parse cert chain: %v", err)
+			log.Fatalf("-grandparent-key does not match -grandparent-chain: %v", err)
+		}
+		err = checkIssuer(chainCerts[0])
+		if err != nil {
+			log.Fatalf("-grandparent-chain can't be used to sign: %v", err)
 		}
 
-		return *parsedCert, priv
+		return *chainCerts[0], priv
 	}
 
 	// ECDSA, ED25519 and RSA subject keys should have the DigitalSignature
@@ -138,17 +138,21 @@ func getAIAParent() (x509.Certificate, any) {
 	//	keyUsage |= x509.KeyUsageKeyEncipherment
 	//}
 
-	var notBefore time.Time
-	if len(*validFrom) == 0 {
-		notBefore = time.Now()
-	} else {
-		notBefore, err = time.Parse("Jan 2 15:04:05 2006", *validFrom)
-		if err != nil {
-			log.Fatalf("Failed to parse creation date: %v", err)
-		}
-	}
+	//var notBefore time.Time
+	//if len(*validFrom) == 0 {
+	//	notBefore = time.Now()
+	//} else {
+	//	notBefore, err = time.Parse("Jan 2 15:04:05 2006", *validFrom)
+	//	if err != nil {
+	//		log.Fatalf("Failed to parse creation date: %v", err)
+	//	}
+	//}
+	notBefore := startDate()
 
-	notAfter := notBefore.Add(*validFor)
+	//notAfter := notBefore.Add(*validFor)
+	notAfter := notBefore.Add(aiaDuration())
+
+	notBefore = notBefore.Add(-aiaBackdateDuration())
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -163,8 +167,10 @@ func getAIAParent() (x509.Certificate, any) {
 			CommonName:   *host + " Domain AIA Parent CA",
 			SerialNumber: "Namecoin TLS Certificate",
 		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
+		//NotBefore: notBefore,
+		NotBefore: floorTimestamp(notBefore),
+		//NotAfter:  notAfter,
+		NotAfter:  floorTimestamp(notAfter),
 
 		IsCA:                  true,
 		KeyUsage:              keyUsage,
@@ -175,6 +181,16 @@ func getAIAParent() (x509.Certificate, any) {
 		PermittedDNSDomains:         []string{*host},
 	}
 
+	// The AIA parent only ever issues the domain CA, so it needs exactly one
+	// more level than the domain CA does.  Encaya rebuilds the AIA parent
+	// rather than serving this template, so it must apply the same
+	// constraint.
+	if *caPathLen >= 0 {
+		template.MaxPathLen = *caPathLen + 1
+	} else {
+		template.MaxPathLen = -1
+	}
+
 	pubBytes, err := x509.MarshalPKIXPublicKey(publicKey(priv))
 	if err != nil {
 		log.Fatalf("failed to marshal AIA CA public key: %v", err)
@@ -183,7 +199,11 @@ func getAIAParent() (x509.Certificate, any) {
 	pubBase64 := base64.RawURLEncoding.EncodeToString(pubBytes)
 
 	// Embed stapled data in Subject Serial Number
-	stapled := map[string]string{"pubb64": pubBase64}
+	// (see stapled.go for the schema).
+	stapled := map[string]string{
+		stapledKeyVersion: stapledVersion,
+		stapledKeyPubB64:  pubBase64,
+	}
 
 	// Staple sigs if requested
 	if *sigs != "" {
@@ -192,16 +212,27 @@ func getAIAParent() (x509.Certificate, any) {
 			log.Fatalf("Failed to read stapled sigs: %v", err)
 		}
 
-		stapled["sigs"] = string(sigsBytes)
+		sigsKey, sigsValue := stapledSigs(sigsBytes)
+		stapled[sigsKey] = sigsValue
 	}
 
-	applyPiDomainAIAParentCA(&template, stapled)
+	applyMetaDomainAIAParentCA(*host, &template, stapled)
 
-	stapledBytes, err := json.Marshal(stapled)
-	if err != nil {
-		log.Fatalf("failed to marshal stapled data: %v", err)
+	if stapledInSerial() {
+		stapledStr, err := encodeStapledData(stapled)
+		if err != nil {
+			log.Fatalf("failed to marshal stapled data: %v", err)
+		}
+		template.Subject.SerialNumber = template.Subject.SerialNumber + stapledStr
+	}
+
+	if stapledInExtension() {
+		stapledExt, err := encodeStapledExtension(stapled)
+		if err != nil {
+			log.Fatalf("failed to marshal stapled data extension: %v", err)
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, stapledExt)
 	}
-	template.Subject.SerialNumber = template.Subject.SerialNumber + "\n\nStapled: " + string(stapledBytes)
 
 	//hosts := strings.Split(*host, ",")
 	//for _, h := range hosts {
@@ -235,33 +266,25 @@ func getAIAParent() (x509.Certificate, any) {
 	//}
 	//log.Print("wrote cert.pem\n")
 
+	if *dryRun {
+		aiaParentPreview = &template
+	}
+
 	writeJSONTLSA(priv)
 
 	if *parentKey != "" {
 		return template, priv
 	}
 
-	//keyOut, err := os.OpenFile("key.pem", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	keyOut, err := os.OpenFile("caAIAKey.pem", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		//log.Fatalf("Failed to open key.pem for writing: %v", err)
-		log.Fatalf("Failed to open caAIAKey.pem for writing: %v", err)
-		//return
-	}
-	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
-	if err != nil {
-		log.Fatalf("Unable to marshal private key: %v", err)
-	}
-	if err := pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}); err != nil {
-		//log.Fatalf("Failed to write data to key.pem: %v", err)
-		log.Fatalf("Failed to write data to caAIAKey.pem: %v", err)
-	}
-	if err := keyOut.Close(); err != nil {
-		//log.Fatalf("Error closing key.pem: %v", err)
-		log.Fatalf("Error closing caAIAKey.pem: %v", err)
+	// Don't rewrite an existing key; it may be encrypted, or may be the very
+	// file we'd be overwriting.
+	if *grandparentKey == "" {
+		privPEM, err := marshalPrivateKeyPEM(priv, encryptCAKeys())
+		if err != nil {
+			log.Fatalf("Unable to marshal private key: %v", err)
+		}
+		stageArtifact("caAIAKey.pem", privPEM, 0600)
 	}
-	//log.Print("wrote key.pem\n")
-	log.Print("wrote caAIAKey.pem\n")
 
 	messageHeader := "Namecoin X.509 Stapled Certification: "
 
@@ -278,12 +301,7 @@ func getAIAParent() (x509.Certificate, any) {
 
 	messageStr := messageHeader + string(messageDataBytes)
 
-	err = ioutil.WriteFile("caAIAMessage.txt", []byte(messageStr), 0600)
-	if err != nil {
-		log.Fatalf("Failed to write data to caAIAMessage.txt: %v", err)
-	}
-
-	log.Print("wrote caAIAMessage.txt")
+	stageArtifact("caAIAMessage.txt", []byte(messageStr), 0600)
 
 	return template, priv
 }
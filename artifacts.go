@@ -0,0 +1,193 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Output files are staged in memory while the hierarchy is generated, and
+// only written once everything has succeeded.  Each file is written to a
+// temporary file in the same directory and fsynced; once all of them are on
+// disk, they are renamed into place one after another.  Each rename is
+// atomic, so no file is ever left half-written, but a run killed during the
+// renames can leave some files old and some new (e.g. a new key.pem next to
+// the old cert.pem).  Keys are renamed before certs, so a new cert is never
+// left in place without its key.  Temporary files left behind by a killed
+// run are removed by the next one.
+
+type artifact struct {
+	name string
+	data []byte
+	perm os.FileMode
+}
+
+var stagedArtifacts []*artifact
+
+// stageArtifact queues data to be written to name by commitArtifacts.
+// Staging the same name twice replaces the earlier data.
+func stageArtifact(name string, data []byte, perm os.FileMode) {
+	for _, a := range stagedArtifacts {
+		if a.name == name {
+			a.data = data
+			a.perm = perm
+			return
+		}
+	}
+
+	stagedArtifacts = append(stagedArtifacts, &artifact{name: name, data: data, perm: perm})
+}
+
+// stagedArtifact returns the data staged for name, if any.
+func stagedArtifact(name string) ([]byte, bool) {
+	for _, a := range stagedArtifacts {
+		if a.name == name {
+			return a.data, true
+		}
+	}
+
+	return nil, false
+}
+
+// writeTemp writes a to a synced temporary file next to its destination and
+// returns the temporary file's name.
+func (a *artifact) writeTemp() (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(a.name), "."+filepath.Base(a.name)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+
+	tmpName := tmp.Name()
+
+	_, err = tmp.Write(a.data)
+	if err == nil {
+		err = tmp.Chmod(a.perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+
+	return tmpName, nil
+}
+
+// removeStaleTemps removes temporary files that an earlier, interrupted run
+// left behind next to a's destination.
+func (a *artifact) removeStaleTemps() {
+	stale, err := filepath.Glob(filepath.Join(filepath.Dir(a.name), "."+filepath.Base(a.name)+".tmp*"))
+	if err != nil {
+		return
+	}
+
+	for _, tmpName := range stale {
+		os.Remove(tmpName)
+	}
+}
+
+// isKeyArtifact returns true if name is a private key file, such as key.pem
+// or caKey.pem.
+func isKeyArtifact(name string) bool {
+	return strings.HasSuffix(strings.ToLower(filepath.Base(name)), "key.pem")
+}
+
+// syncDir fsyncs a directory so that renames within it are durable.  Not all
+// platforms support this, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	_ = d.Sync()
+	_ = d.Close()
+}
+
+// writeArtifacts writes artifacts to disk, as described above.
+func writeArtifacts(artifacts []*artifact) error {
+	// Keys first; otherwise keep the staging order.
+	ordered := make([]*artifact, 0, len(artifacts))
+	for _, a := range artifacts {
+		if isKeyArtifact(a.name) {
+			ordered = append(ordered, a)
+		}
+	}
+	for _, a := range artifacts {
+		if !isKeyArtifact(a.name) {
+			ordered = append(ordered, a)
+		}
+	}
+	artifacts = ordered
+
+	for _, a := range artifacts {
+		a.removeStaleTemps()
+	}
+
+	tmpNames := make([]string, 0, len(artifacts))
+
+	cleanup := func() {
+		for _, tmpName := range tmpNames {
+			os.Remove(tmpName)
+		}
+	}
+
+	for _, a := range artifacts {
+		tmpName, err := a.writeTemp()
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("failed to write %s: %w", a.name, err)
+		}
+
+		tmpNames = append(tmpNames, tmpName)
+	}
+
+	// Nothing in this loop may block or fail for reasons other than the
+	// renames themselves (logging to a closed pipe could kill us with
+	// SIGPIPE), to keep the window for a partial update small.
+	dirs := map[string]bool{}
+	for i, a := range artifacts {
+		err := os.Rename(tmpNames[i], a.name)
+		if err != nil {
+			cleanup()
+			return fmt.Errorf("failed to rename %s into place: %w", a.name, err)
+		}
+
+		dirs[filepath.Dir(a.name)] = true
+	}
+
+	for dir := range dirs {
+		syncDir(dir)
+	}
+
+	for _, a := range artifacts {
+		log.Printf("wrote %s\n", a.name)
+	}
+
+	return nil
+}
+
+// writeFileAtomic atomically writes a single file.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	return writeArtifacts([]*artifact{{name: name, data: data, perm: perm}})
+}
+
+// commitArtifacts writes all staged artifacts to disk.
+func commitArtifacts() {
+	err := writeArtifacts(stagedArtifacts)
+	if err != nil {
+		log.Fatalf("Failed to write output files: %v", err)
+	}
+
+	stagedArtifacts = nil
+}
@@ -5,91 +5,186 @@
 package main
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"io/ioutil"
 	"log"
-	"os"
+	"time"
 )
 
-func writeChain() {
-	chainOut, err := os.Create("chain.pem")
-	if err != nil {
-		log.Fatalf("Failed to open chain.pem for writing: %v", err)
+// parseCertChain parses every PEM block in chainPEM.  Anything other than
+// CERTIFICATE blocks (and surrounding whitespace) is rejected, as is a chain
+// whose certificates don't each issue the one before them.
+func parseCertChain(chainPEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	rest := chainPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("block %d is a %q PEM block, expected CERTIFICATE", len(certs)+1, block.Type)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", len(certs)+1, err)
+		}
+
+		certs = append(certs, cert)
 	}
 
-	leafCert, err := ioutil.ReadFile("cert.pem")
-	if err != nil {
-		log.Fatalf("Failed to read cert.pem: %v", err)
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificates found")
 	}
 
-	_, err = chainOut.Write(leafCert)
-	if err != nil {
-		log.Fatalf("Failed to write end-entity cert to chain.pem: %v", err)
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, fmt.Errorf("unparseable data after block %d", len(certs))
 	}
 
-	caChainOut, err := os.Create("caChain.pem")
+	err := checkChainLinkage(certs)
 	if err != nil {
-		log.Fatalf("Failed to open caChain.pem for writing: %v", err)
+		return nil, err
 	}
 
-	_, err = chainOut.WriteString("\n\n")
-	if err != nil {
-		log.Fatalf("Failed to write CA cert padding to chain.pem: %v", err)
+	return certs, nil
+}
+
+// checkChainLinkage verifies that each certificate in certs is issued by the
+// certificate that follows it.
+func checkChainLinkage(certs []*x509.Certificate) error {
+	for i := 0; i+1 < len(certs); i++ {
+		child, issuer := certs[i], certs[i+1]
+
+		if !bytes.Equal(child.RawIssuer, issuer.RawSubject) {
+			return fmt.Errorf("cert %d (%q) was issued by %q, but cert %d is %q", i+1, child.Subject.CommonName, child.Issuer.CommonName, i+2, issuer.Subject.CommonName)
+		}
+
+		err := child.CheckSignatureFrom(issuer)
+		if err != nil {
+			return fmt.Errorf("cert %d (%q) is not signed by cert %d: %w", i+1, child.Subject.CommonName, i+2, err)
+		}
 	}
 
-	parentToRead := "caCert.pem"
-	if *parentChain != "" {
-		parentToRead = *parentChain
+	return nil
+}
+
+// checkIssuer returns an error unless cert is a currently valid CA that is
+// allowed to sign certificates.
+func checkIssuer(cert *x509.Certificate) error {
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return fmt.Errorf("%q is not a CA", cert.Subject.CommonName)
 	}
 
-	caCert, err := ioutil.ReadFile(parentToRead)
-	if err != nil {
-		log.Fatalf("Failed to read %s: %v", parentToRead, err)
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return fmt.Errorf("%q does not have the CertSign key usage", cert.Subject.CommonName)
 	}
 
-	_, err = chainOut.Write(caCert)
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("%q expired at %s", cert.Subject.CommonName, cert.NotAfter.UTC())
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("%q is not valid until %s", cert.Subject.CommonName, cert.NotBefore.UTC())
+	}
+
+	return nil
+}
+
+// readCertChain reads and parses a PEM cert chain file.
+func readCertChain(path string) ([]*x509.Certificate, error) {
+	chainPEM, err := ioutil.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Failed to write CA cert to chain.pem: %v", err)
+		return nil, err
 	}
 
-	_, err = caChainOut.Write(caCert)
+	certs, err := parseCertChain(chainPEM)
 	if err != nil {
-		log.Fatalf("Failed to write CA cert to caChain.pem: %v", err)
+		return nil, fmt.Errorf("%s: %w", path, err)
 	}
 
-	if *grandparentChain != "" {
-		_, err = chainOut.WriteString("\n\n")
-		if err != nil {
-			log.Fatalf("Failed to write grandparent CA cert padding to chain.pem: %v", err)
-		}
+	return certs, nil
+}
 
-		_, err = caChainOut.WriteString("\n\n")
-		if err != nil {
-			log.Fatalf("Failed to write grandparent CA cert padding to caChain.pem: %v", err)
+// encodeCertChain PEM-encodes certs, separated by the same padding that
+// ncgencert has always used in chain.pem.
+func encodeCertChain(certs []*x509.Certificate) []byte {
+	return joinCertChain(certs, "\n\n")
+}
+
+// encodeFullChain PEM-encodes certs with no padding, as most servers expect
+// for a fullchain.pem.
+func encodeFullChain(certs []*x509.Certificate) []byte {
+	return joinCertChain(certs, "")
+}
+
+func joinCertChain(certs []*x509.Certificate, padding string) []byte {
+	var result []byte
+
+	for i, cert := range certs {
+		if i != 0 {
+			result = append(result, []byte(padding)...)
 		}
 
-		grandparentCert, err := ioutil.ReadFile(*grandparentChain)
+		result = append(result, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return result
+}
+
+// stagedCertChain parses a cert chain staged by an earlier step of this run.
+func stagedCertChain(name string) ([]*x509.Certificate, error) {
+	chainPEM, ok := stagedArtifact(name)
+	if !ok {
+		return nil, fmt.Errorf("%s was not generated", name)
+	}
+
+	return parseCertChain(chainPEM)
+}
+
+// writeChain stages chain.pem and caChain.pem, built from the cert.pem and
+// caCert.pem staged earlier in this run (or the existing -parent-chain).
+func writeChain() {
+	leafCerts, err := stagedCertChain("cert.pem")
+	if err != nil {
+		log.Fatalf("Failed to parse cert.pem: %v", err)
+	}
+
+	var caCerts []*x509.Certificate
+	if *parentChain != "" {
+		caCerts, err = readCertChain(*parentChain)
 		if err != nil {
-			log.Fatalf("Failed to read %s: %v", *grandparentChain, err)
+			log.Fatalf("Failed to read %s: %v", *parentChain, err)
 		}
-
-		_, err = chainOut.Write(grandparentCert)
+	} else {
+		caCerts, err = stagedCertChain("caCert.pem")
 		if err != nil {
-			log.Fatalf("Failed to write grandparent CA cert to chain.pem: %v", err)
+			log.Fatalf("Failed to parse caCert.pem: %v", err)
 		}
+	}
 
-		_, err = caChainOut.Write(grandparentCert)
+	if *grandparentChain != "" {
+		grandparentCerts, err := readCertChain(*grandparentChain)
 		if err != nil {
-			log.Fatalf("Failed to write grandparent CA cert to caChain.pem: %v", err)
+			log.Fatalf("Failed to read %s: %v", *grandparentChain, err)
 		}
-	}
 
-	if err := chainOut.Close(); err != nil {
-		log.Fatalf("Error closing chain.pem: %v", err)
+		caCerts = append(caCerts, grandparentCerts...)
 	}
-	log.Print("wrote chain.pem\n")
 
-	if err := caChainOut.Close(); err != nil {
-		log.Fatalf("Error closing caChain.pem: %v", err)
+	fullCerts := append(leafCerts, caCerts...)
+
+	err = checkChainLinkage(fullCerts)
+	if err != nil {
+		log.Fatalf("Generated cert chain is inconsistent: %v", err)
 	}
-	log.Print("wrote caChain.pem\n")
+
+	stageArtifact("chain.pem", encodeCertChain(fullCerts), 0644)
+	stageArtifact("caChain.pem", encodeCertChain(caCerts), 0644)
 }
@@ -0,0 +1,275 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tiers reported by check-expiry, identified by the Subject CNs that
+// ncgencert gives its certs.  Certs from other CAs (e.g. a -parent-chain that
+// wasn't made by ncgencert) are reported as "other-ca".
+const (
+	tierEndEntity   = "end-entity"
+	tierDomainCA    = "domain-ca"
+	tierDelegatedCA = "delegated-ca"
+	tierAIAParent   = "aia-parent"
+	tierOtherCA     = "other-ca"
+)
+
+func certTier(cert *x509.Certificate) string {
+	switch {
+	case !cert.IsCA:
+		return tierEndEntity
+	case strings.HasSuffix(cert.Subject.CommonName, " Domain AIA Parent CA"):
+		return tierAIAParent
+	case strings.HasSuffix(cert.Subject.CommonName, " Domain CA"):
+		return tierDomainCA
+	case strings.HasSuffix(cert.Subject.CommonName, " Delegated CA"):
+		return tierDelegatedCA
+	default:
+		return tierOtherCA
+	}
+}
+
+// errNoCerts is returned by scanExpiryFile for PEM files without certs, such
+// as key.pem.
+var errNoCerts = errors.New("no certs found")
+
+// chainExpiry is the earliest-expiring cert of one tier of the cert chain in
+// one file.
+type chainExpiry struct {
+	path string
+	tier string
+	cert *x509.Certificate
+}
+
+// expiryScan collects chainExpiry results.  The same cert usually turns up in
+// several files (e.g. caCert.pem, chain.pem, caChain.pem and fullchain.pem),
+// so each cert is only reported for the first file it's found in, to avoid
+// duplicate metrics.
+type expiryScan struct {
+	results []*chainExpiry
+	seen    map[[sha256.Size]byte]bool
+}
+
+func newExpiryScan() *expiryScan {
+	return &expiryScan{seen: map[[sha256.Size]byte]bool{}}
+}
+
+// checkExpiryMain implements "ncgencert check-expiry".
+func checkExpiryMain(args []string) {
+	flags := flag.NewFlagSet("check-expiry", flag.ExitOnError)
+	threshold := flags.Duration("threshold", 30*24*time.Hour, "Exit with status 1 if any cert expires within this long")
+	prometheus := flags.String("prometheus", "", "(Optional) Path of a Prometheus textfile-collector file (e.g. /var/lib/node_exporter/ncgencert.prom) to write metrics to")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s check-expiry [flags] PATH...\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Reports the earliest expiry of each tier of each cert chain in the given PEM\nfiles, or in the *.pem files under the given directories.  Certs found in\nseveral files are only reported for the first.\n\n")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	scan := newExpiryScan()
+	for _, path := range flags.Args() {
+		err = scan.scanPath(path)
+		if err != nil {
+			log.Fatalf("Failed to scan %s: %v", path, err)
+		}
+	}
+
+	if len(scan.results) == 0 {
+		log.Fatalf("No certs found")
+	}
+
+	results := scan.results
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].path != results[j].path {
+			return results[i].path < results[j].path
+		}
+
+		return results[i].tier < results[j].tier
+	})
+
+	now := time.Now()
+	expiring := reportExpiry(os.Stdout, results, now, *threshold)
+
+	if *prometheus != "" {
+		err = writeFileAtomic(*prometheus, expiryMetrics(results, now, *threshold), 0644)
+		if err != nil {
+			log.Fatalf("Failed to write Prometheus metrics: %v", err)
+		}
+	}
+
+	if expiring {
+		os.Exit(1)
+	}
+}
+
+// reportExpiry prints results, grouped by path, and returns true if any of
+// them expires within threshold.
+func reportExpiry(w io.Writer, results []*chainExpiry, now time.Time, threshold time.Duration) bool {
+	expiring := false
+
+	lastPath := ""
+	for _, r := range results {
+		if r.path != lastPath {
+			fmt.Fprintf(w, "%s:\n", r.path)
+			lastPath = r.path
+		}
+
+		remaining := r.cert.NotAfter.Sub(now)
+
+		status := "OK"
+		if remaining <= 0 {
+			status = "EXPIRED"
+			expiring = true
+		} else if remaining <= threshold {
+			status = "EXPIRING"
+			expiring = true
+		}
+
+		fmt.Fprintf(w, "  %-9s %-13s %s  (%s left)  %q\n", status, r.tier, r.cert.NotAfter.UTC().Format(time.RFC3339), remaining.Truncate(time.Hour), r.cert.Subject.CommonName)
+	}
+
+	return expiring
+}
+
+// scanPath records the earliest-expiring cert of each tier of each cert
+// chain found at path.  Files named explicitly must be cert chains; files
+// found by walking a directory are skipped if they aren't.
+func (s *expiryScan) scanPath(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return s.scanFile(path)
+	}
+
+	return filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(filePath) != ".pem" {
+			return nil
+		}
+
+		err = s.scanFile(filePath)
+		if err != nil && !errors.Is(err, errNoCerts) {
+			log.Printf("Skipping %s: %v", filePath, err)
+		}
+
+		return nil
+	})
+}
+
+func (s *expiryScan) scanFile(path string) error {
+	chainPEM, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Only the cert blocks matter here, so combined.pem (which ends with a
+	// key) can be scanned too.
+	var certsPEM bytes.Buffer
+	for rest := chainPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "CERTIFICATE" {
+			_ = pem.Encode(&certsPEM, block)
+		}
+	}
+
+	if certsPEM.Len() == 0 {
+		return errNoCerts
+	}
+
+	certs, err := parseCertChain(certsPEM.Bytes())
+	if err != nil {
+		return err
+	}
+
+	earliest := map[string]*chainExpiry{}
+	for _, cert := range certs {
+		fingerprint := sha256.Sum256(cert.Raw)
+		if s.seen[fingerprint] {
+			continue
+		}
+		s.seen[fingerprint] = true
+
+		tier := certTier(cert)
+
+		e, ok := earliest[tier]
+		if !ok {
+			e = &chainExpiry{path: path, tier: tier, cert: cert}
+			earliest[tier] = e
+			s.results = append(s.results, e)
+		} else if cert.NotAfter.Before(e.cert.NotAfter) {
+			e.cert = cert
+		}
+	}
+
+	return nil
+}
+
+// promLabelEscaper escapes Prometheus label values.  Go's %q would escape
+// more than the text exposition format understands.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func expiryMetrics(results []*chainExpiry, now time.Time, threshold time.Duration) []byte {
+	var out bytes.Buffer
+
+	fmt.Fprintln(&out, "# HELP ncgencert_cert_not_after_timestamp_seconds Earliest NotAfter of the certs in each tier of each cert chain.")
+	fmt.Fprintln(&out, "# TYPE ncgencert_cert_not_after_timestamp_seconds gauge")
+	for _, r := range results {
+		fmt.Fprintf(&out, "ncgencert_cert_not_after_timestamp_seconds{path=\"%s\",tier=\"%s\"} %d\n", promLabelEscaper.Replace(r.path), r.tier, r.cert.NotAfter.Unix())
+	}
+
+	fmt.Fprintln(&out, "# HELP ncgencert_cert_expiring Whether a cert in each tier of each cert chain expires within the check-expiry threshold.")
+	fmt.Fprintln(&out, "# TYPE ncgencert_cert_expiring gauge")
+	for _, r := range results {
+		expiring := 0
+		if r.cert.NotAfter.Sub(now) <= threshold {
+			expiring = 1
+		}
+		fmt.Fprintf(&out, "ncgencert_cert_expiring{path=\"%s\",tier=\"%s\"} %d\n", promLabelEscaper.Replace(r.path), r.tier, expiring)
+	}
+
+	fmt.Fprintln(&out, "# HELP ncgencert_check_expiry_last_run_timestamp_seconds When check-expiry last ran.")
+	fmt.Fprintln(&out, "# TYPE ncgencert_check_expiry_last_run_timestamp_seconds gauge")
+	fmt.Fprintf(&out, "ncgencert_check_expiry_last_run_timestamp_seconds %d\n", now.Unix())
+
+	return out.Bytes()
+}
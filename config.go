@@ -0,0 +1,122 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// A config file sets flags by name, without the leading dash, e.g. in TOML:
+//
+//	host = "example.bit"
+//	duration = "8760h"
+//	ecdsa-curve = "P384"
+//	parent-key = "pkcs11:token=ncgencert;object=domain-ca"
+//	output-formats = ["der", "fullchain"]
+//
+// or the same keys in YAML.  Lists are joined with commas, for flags such as
+// -host and -output-formats that take comma-separated values.  Flags given on
+// the command line override the config file.
+
+// loadConfigFile reads the TOML or YAML config file at path, as selected by
+// its extension, and sets every flag it names that hasn't already been set.
+func loadConfigFile(path string) error {
+	configBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	config := map[string]any{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		err = toml.Unmarshal(configBytes, &config)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(configBytes, &config)
+	default:
+		return fmt.Errorf("unrecognized config file extension %q (expected .toml, .yaml or .yml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return err
+	}
+
+	alreadySet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		alreadySet[f.Name] = true
+	})
+
+	for name, value := range config {
+		if name == "config" {
+			return fmt.Errorf("config files can't include other config files")
+		}
+
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q", name)
+		}
+
+		if alreadySet[name] {
+			continue
+		}
+
+		valueStr, err := configValueString(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+
+		err = flag.Set(name, valueStr)
+		if err != nil {
+			return fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// configValueString converts a decoded TOML or YAML value to the string form
+// that its flag would accept on the command line.
+func configValueString(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case time.Time:
+		// TOML local dates and datetimes have no zone of their own, so
+		// leave them zoneless for flags like -start-date to interpret in
+		// -time-zone.
+		switch v.Location().String() {
+		case "date-local":
+			return v.Format("2006-01-02"), nil
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999"), nil
+		case "time-local":
+			return "", fmt.Errorf("unsupported time of day without a date")
+		}
+
+		return v.Format(time.RFC3339Nano), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			itemStr, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+
+			items = append(items, itemStr)
+		}
+
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", value)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfigValueStringDates(t *testing.T) {
+	tests := []struct {
+		name   string
+		decode func(string, *map[string]any) error
+		config string
+		want   string
+	}{
+		{"TOML offset datetime", tomlDecode, "d = 2026-01-02T03:04:05+02:00", "2026-01-02T03:04:05+02:00"},
+		{"TOML local datetime", tomlDecode, "d = 2026-01-02T03:04:05", "2026-01-02T03:04:05"},
+		{"TOML local date", tomlDecode, "d = 2026-01-02", "2026-01-02"},
+		{"YAML timestamp", yamlDecode, "d: 2026-01-02T03:04:05Z", "2026-01-02T03:04:05Z"},
+		{"YAML date", yamlDecode, "d: 2026-01-02", "2026-01-02T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		settings := map[string]any{}
+		err := test.decode(test.config, &settings)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		got, err := configValueString(settings["d"])
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	settings := map[string]any{}
+	err := tomlDecode("d = 03:04:05", &settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = configValueString(settings["d"])
+	if err == nil {
+		t.Error("TOML local time succeeded; expected an error")
+	}
+}
+
+func tomlDecode(config string, settings *map[string]any) error {
+	_, err := toml.Decode(config, settings)
+	return err
+}
+
+func yamlDecode(config string, settings *map[string]any) error {
+	return yaml.Unmarshal([]byte(config), settings)
+}
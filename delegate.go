@@ -0,0 +1,169 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// domainWithin returns true if name is equal to, or a subdomain of, the DNS
+// name constraint.
+func domainWithin(name, constraint string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	constraint = strings.ToLower(strings.Trim(constraint, "."))
+
+	return name == constraint || strings.HasSuffix(name, "."+constraint)
+}
+
+// sameFile returns true if path refers to the same file as the output
+// artifact name.  Missing files are never considered the same.
+func sameFile(path, name string) bool {
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	nameInfo, err := os.Stat(name)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(pathInfo, nameInfo)
+}
+
+// writeDelegatedCA issues an intermediate CA constrained to -host, signed by
+// the existing CA given by -parent-key and -parent-chain.  The output
+// (caCert.pem, caKey.pem and caChain.pem) can be handed to the owner of the
+// subdomain, who can then issue their own end-entity certs with
+// "-parent-key caKey.pem -parent-chain caChain.pem".
+func writeDelegatedCA() {
+	if *parentKey == "" || *parentChain == "" {
+		log.Fatalf("-delegate requires both -parent-key and -parent-chain")
+	}
+
+	if *delegatePathLen < 0 {
+		log.Fatalf("-delegate-path-len must not be negative")
+	}
+
+	for _, input := range []string{*parentKey, *parentChain} {
+		for _, output := range []string{"caCert.pem", "caKey.pem", "caChain.pem"} {
+			if sameFile(input, output) {
+				log.Fatalf("%s would be overwritten by the delegated CA; run ncgencert -delegate from a different directory", input)
+			}
+		}
+	}
+
+	parent, parentPriv := getParent()
+
+	if parent.MaxPathLen == 0 && parent.MaxPathLenZero {
+		log.Fatalf("Parent CA has a path length constraint of 0 and cannot issue intermediate CAs; regenerate it with -ca-path-len 1 or greater")
+	}
+	if parent.MaxPathLen > 0 && *delegatePathLen >= parent.MaxPathLen {
+		log.Fatalf("-delegate-path-len must be less than the parent CA's path length constraint (%d)", parent.MaxPathLen)
+	}
+
+	hosts := strings.Split(*host, ",")
+	for _, h := range hosts {
+		if len(parent.PermittedDNSDomains) == 0 {
+			break
+		}
+
+		permitted := false
+		for _, constraint := range parent.PermittedDNSDomains {
+			if domainWithin(h, constraint) {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			log.Fatalf("%s is not within the parent CA's permitted domains %v", h, parent.PermittedDNSDomains)
+		}
+	}
+
+	priv, err := generatePrivateKey()
+	if err != nil {
+		log.Fatalf("Failed to generate private key: %v", err)
+	}
+
+	notBefore := startDate()
+
+	notAfter := notBefore.Add(caDuration())
+
+	notBefore = notBefore.Add(-caBackdateDuration())
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		log.Fatalf("Failed to generate serial number: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   *host + " Delegated CA",
+			SerialNumber: "Namecoin TLS Certificate",
+		},
+		NotBefore: floorTimestamp(notBefore),
+		NotAfter:  floorTimestamp(notAfter),
+
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		MaxPathLen:            *delegatePathLen,
+		MaxPathLenZero:        *delegatePathLen == 0,
+
+		PermittedDNSDomainsCritical: true,
+		PermittedDNSDomains:         hosts,
+	}
+
+	err = checkValidityWithin(&template, &parent)
+	if err != nil {
+		log.Fatalf("Invalid -ca-duration: %v", err)
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &parent, publicKey(priv), parentPriv)
+	if err != nil {
+		log.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(derBytes)
+	if err != nil {
+		log.Fatalf("Failed to parse certificate: %v", err)
+	}
+
+	parentCerts, err := readCertChain(*parentChain)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *parentChain, err)
+	}
+
+	caChain := append([]*x509.Certificate{cert}, parentCerts...)
+
+	stageArtifact("caCert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0644)
+
+	privPEM, err := marshalPrivateKeyPEM(priv, encryptCAKeys())
+	if err != nil {
+		log.Fatalf("Unable to marshal private key: %v", err)
+	}
+	stageArtifact("caKey.pem", privPEM, 0600)
+
+	stageArtifact("caChain.pem", encodeCertChain(caChain), 0644)
+
+	if *dryRun {
+		printDryRun()
+		return
+	}
+
+	commitArtifacts()
+
+	log.Print("SUCCESS. Give caChain.pem and caKey.pem to the operator of " + *host + "; they can issue end-entity certs with \"-parent-key caKey.pem -parent-chain caChain.pem\".")
+}
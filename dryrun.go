@@ -0,0 +1,161 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// aiaParentPreview is the AIA parent template built during a dry run.  The
+// AIA parent is never written as a cert, so -dry-run can't find it among the
+// staged artifacts.
+var aiaParentPreview *x509.Certificate
+
+// printDryRun shows the hierarchy built from the staged artifacts, and the
+// files that commitArtifacts would have written.
+func printDryRun() {
+	fmt.Println("DRY RUN: no files have been written.")
+
+	if aiaParentPreview != nil {
+		printCertPreview("AIA parent CA (template only; served via AIA, not written)", aiaParentPreview)
+	}
+
+	var certs []*x509.Certificate
+	var err error
+	if _, ok := stagedArtifact("chain.pem"); ok {
+		certs, err = stagedCertChain("chain.pem")
+	} else {
+		// -delegate doesn't write chain.pem.
+		certs, err = stagedCertChain("caChain.pem")
+	}
+	if err != nil {
+		log.Fatalf("Failed to parse generated cert chain: %v", err)
+	}
+
+	leafPEM, _ := stagedArtifact("cert.pem")
+	caPEM, _ := stagedArtifact("caCert.pem")
+
+	// Print from the top of the hierarchy down, like the AIA parent above.
+	for i := len(certs) - 1; i >= 0; i-- {
+		cert := certs[i]
+
+		var label string
+		switch {
+		case isStagedCert(leafPEM, cert):
+			label = "End-entity cert (cert.pem)"
+		case isStagedCert(caPEM, cert) && *delegate:
+			label = "Delegated CA (caCert.pem)"
+		case isStagedCert(caPEM, cert):
+			label = "Domain CA (caCert.pem)"
+		default:
+			label = "Existing CA"
+		}
+
+		printCertPreview(label, cert)
+	}
+
+	if tlsa, ok := stagedArtifact("namecoin.json"); ok {
+		fmt.Println()
+		fmt.Printf("TLSA record (namecoin.json): %s\n", tlsa)
+	}
+
+	fmt.Println()
+	fmt.Println("Files:")
+	for _, a := range stagedArtifacts {
+		action := "create"
+		_, err := os.Stat(a.name)
+		if err == nil {
+			action = "overwrite"
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			action = fmt.Sprintf("unknown (%v)", err)
+		}
+
+		fmt.Printf("  %-20s %04o  %s\n", a.name, a.perm, action)
+	}
+}
+
+// isStagedCert returns true if certPEM, as staged for cert.pem or
+// caCert.pem, holds cert.
+func isStagedCert(certPEM []byte, cert *x509.Certificate) bool {
+	if certPEM == nil {
+		return false
+	}
+
+	certs, err := parseCertChain(certPEM)
+	if err != nil || len(certs) != 1 {
+		return false
+	}
+
+	return bytes.Equal(certs[0].Raw, cert.Raw)
+}
+
+func printCertPreview(label string, cert *x509.Certificate) {
+	fmt.Println()
+	fmt.Println(label + ":")
+
+	// The Subject Serial Number of Namecoin certs carries the stapled data
+	// after a blank line; show that separately.
+	serial, stapled, _ := strings.Cut(cert.Subject.SerialNumber, stapledPrefix)
+
+	fmt.Printf("  Subject CN:       %s\n", cert.Subject.CommonName)
+	fmt.Printf("  Subject Serial:   %s\n", serial)
+	if cert.Issuer.CommonName != "" {
+		fmt.Printf("  Issuer CN:        %s\n", cert.Issuer.CommonName)
+	}
+	fmt.Printf("  Not Before:       %s\n", cert.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Printf("  Not After:        %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+
+	if cert.IsCA {
+		pathLen := "unlimited"
+		if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+			pathLen = fmt.Sprintf("%d", cert.MaxPathLen)
+		}
+		fmt.Printf("  CA:               yes (path length %s)\n", pathLen)
+	}
+
+	if len(cert.DNSNames) > 0 {
+		fmt.Printf("  DNS Names:        %s\n", strings.Join(cert.DNSNames, ", "))
+	}
+	if len(cert.PermittedDNSDomains) > 0 {
+		fmt.Printf("  Permitted DNS:    %s\n", strings.Join(cert.PermittedDNSDomains, ", "))
+	}
+	if len(cert.ExcludedDNSDomains) > 0 {
+		fmt.Printf("  Excluded DNS:     %s\n", strings.Join(cert.ExcludedDNSDomains, ", "))
+	}
+	if len(cert.PermittedIPRanges) > 0 || len(cert.ExcludedIPRanges) > 0 {
+		fmt.Printf("  IP Constraints:   %d permitted, %d excluded\n", len(cert.PermittedIPRanges), len(cert.ExcludedIPRanges))
+	}
+
+	for _, aiaURL := range cert.IssuingCertificateURL {
+		fmt.Printf("  AIA URL:          %s\n", aiaURL)
+	}
+
+	if stapled != "" {
+		fmt.Printf("  Stapled Data:     %s\n", stapled)
+	}
+
+	// Templates (i.e. the AIA parent) only have ExtraExtensions.
+	for _, ext := range append(cert.Extensions, cert.ExtraExtensions...) {
+		if !ext.Id.Equal(oidStapledData) {
+			continue
+		}
+
+		var stapledExt string
+		_, err := asn1.UnmarshalWithParams(ext.Value, &stapledExt, "utf8")
+		if err != nil {
+			stapledExt = fmt.Sprintf("malformed (%v)", err)
+		}
+		fmt.Printf("  Stapled Ext:      %s\n", stapledExt)
+	}
+}
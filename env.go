@@ -0,0 +1,70 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const envVarPrefix = "NCGENCERT_"
+
+func init() {
+	flag.Usage = usage
+}
+
+// envVarName returns the environment variable that sets the named flag, e.g.
+// NCGENCERT_PARENT_KEY for -parent-key.
+func envVarName(flagName string) string {
+	return envVarPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadEnvironment sets every flag that wasn't given on the command line but
+// has an NCGENCERT_* environment variable.
+func loadEnvironment() error {
+	alreadySet := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		alreadySet[f.Name] = true
+	})
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || alreadySet[f.Name] {
+			return
+		}
+
+		value, ok := os.LookupEnv(envVarName(f.Name))
+		if !ok {
+			return
+		}
+
+		setErr := flag.Set(f.Name, value)
+		if setErr != nil {
+			err = fmt.Errorf("invalid value for %s: %w", envVarName(f.Name), setErr)
+		}
+	})
+
+	return err
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, `
+Every flag can also be set with an environment variable named after it, e.g.
+%s for -host and %s for -parent-key,
+or in the file given by -config (or %s).
+
+Settings are taken from, in order of precedence:
+  1. flags on the command line
+  2. %s* environment variables
+  3. the -config file
+  4. the defaults shown above
+`, envVarName("host"), envVarName("parent-key"), envVarName("config"), envVarPrefix)
+}
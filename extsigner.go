@@ -0,0 +1,193 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// External signers speak a line-based JSON protocol, either on the
+// stdin/stdout of a child process ("exec:/path/to/signer args...") or over a
+// Unix socket ("unix:/path/to/socket").  Each request is one JSON object on
+// one line, answered by one JSON object on one line.
+//
+// {"op":"public-key"} is answered with {"public_key":"<base64 PKIX DER>"}.
+//
+// {"op":"sign","algorithm":"ECDSA","hash":"SHA-256","digest":"<base64>"} is
+// answered with {"signature":"<base64>"}.  algorithm is one of ECDSA,
+// RSA-PKCS1v15, RSA-PSS (salt length equal to the hash length) or Ed25519.
+// For Ed25519, hash is empty and the whole message to sign is sent in
+// "message" instead of "digest".  ECDSA signatures are ASN.1 DER, as in X.509.
+//
+// Any response may instead be {"error":"<message>"}.
+//
+// testdata/stubsigner is a minimal implementation for testing.
+
+type extSignerRequest struct {
+	Op        string `json:"op"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Digest    []byte `json:"digest,omitempty"`
+	Message   []byte `json:"message,omitempty"`
+}
+
+type extSignerResponse struct {
+	PublicKey []byte `json:"public_key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type extSigner struct {
+	name string
+	in   *json.Encoder
+	out  *bufio.Scanner
+	pub  crypto.PublicKey
+}
+
+// isExtSignerPath returns true if a -parent-key or -grandparent-key value
+// refers to an external signer rather than a file.
+func isExtSignerPath(path string) bool {
+	return strings.HasPrefix(path, "exec:") || strings.HasPrefix(path, "unix:")
+}
+
+// loadExtSigner connects to the external signer at path and fetches its
+// public key.
+func loadExtSigner(path string) (crypto.Signer, error) {
+	var reader io.Reader
+	var writer io.Writer
+
+	switch {
+	case strings.HasPrefix(path, "exec:"):
+		args := strings.Fields(strings.TrimPrefix(path, "exec:"))
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no external signer command given")
+		}
+
+		cmd := exec.Command(args[0], args[1:]...) // nolint: gosec
+		cmd.Stderr = os.Stderr
+
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		err = cmd.Start()
+		if err != nil {
+			return nil, fmt.Errorf("failed to start external signer: %w", err)
+		}
+
+		// The signer exits when its stdin is closed, which happens when
+		// ncgencert exits.
+		reader, writer = stdout, stdin
+	case strings.HasPrefix(path, "unix:"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(path, "unix:"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to external signer: %w", err)
+		}
+
+		reader, writer = conn, conn
+	default:
+		return nil, fmt.Errorf("not an external signer: %q", path)
+	}
+
+	signer := &extSigner{
+		name: path,
+		in:   json.NewEncoder(writer),
+		out:  bufio.NewScanner(reader),
+	}
+
+	resp, err := signer.call(&extSignerRequest{Op: "public-key"})
+	if err != nil {
+		return nil, err
+	}
+
+	signer.pub, err = x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("external signer returned an invalid public key: %w", err)
+	}
+
+	return signer, nil
+}
+
+func (s *extSigner) call(req *extSignerRequest) (*extSignerResponse, error) {
+	err := s.in.Encode(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send %s request to external signer %s: %w", req.Op, s.name, err)
+	}
+
+	if !s.out.Scan() {
+		err = s.out.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, fmt.Errorf("no %s response from external signer %s: %w", req.Op, s.name, err)
+	}
+
+	resp := &extSignerResponse{}
+	err = json.Unmarshal(s.out.Bytes(), resp)
+	if err != nil {
+		return nil, fmt.Errorf("malformed %s response from external signer %s: %w", req.Op, s.name, err)
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("external signer %s: %s", s.name, resp.Error)
+	}
+
+	return resp, nil
+}
+
+func (s *extSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *extSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := &extSignerRequest{Op: "sign"}
+
+	switch s.pub.(type) {
+	case *ecdsa.PublicKey:
+		req.Algorithm = "ECDSA"
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			req.Algorithm = "RSA-PSS"
+		} else {
+			req.Algorithm = "RSA-PKCS1v15"
+		}
+	case ed25519.PublicKey:
+		req.Algorithm = "Ed25519"
+	default:
+		return nil, fmt.Errorf("unsupported external signer public key type %T", s.pub)
+	}
+
+	if opts.HashFunc() == 0 {
+		req.Message = digest
+	} else {
+		req.Hash = opts.HashFunc().String()
+		req.Digest = digest
+	}
+
+	resp, err := s.call(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Signature, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var stubSignerKeys = []struct {
+	name     string
+	generate func() (crypto.Signer, error)
+}{
+	{"ECDSA", func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }},
+	{"Ed25519", func() (crypto.Signer, error) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}},
+}
+
+// buildStubSigner builds testdata/stubsigner into dir.
+func buildStubSigner(t *testing.T, dir string) string {
+	t.Helper()
+
+	bin := filepath.Join(dir, "stubsigner")
+
+	cmd := exec.Command("go", "build", "-o", bin, "./testdata/stubsigner")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to build stubsigner: %v\n%s", err, out)
+	}
+
+	return bin
+}
+
+// writeStubSignerKey writes priv to dir as the unencrypted PKCS#8 key file
+// that stubsigner expects.
+func writeStubSignerKey(t *testing.T, dir string, priv crypto.Signer) string {
+	t.Helper()
+
+	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return keyPath
+}
+
+// checkExtSigner loads the external signer at path and checks that it signs
+// certs as priv would.
+func checkExtSigner(t *testing.T, path string, priv crypto.Signer) {
+	t.Helper()
+
+	signer, err := loadExtSigner(path)
+	if err != nil {
+		t.Fatalf("loadExtSigner: %v", err)
+	}
+
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(priv.Public()) {
+		t.Fatalf("external signer public key %v doesn't match %v", signer.Public(), priv.Public())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stubsigner test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatalf("Failed to sign cert with external signer: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cert.CheckSignatureFrom(cert)
+	if err != nil {
+		t.Fatalf("External signer made an invalid signature: %v", err)
+	}
+}
+
+func TestExtSignerExec(t *testing.T) {
+	bin := buildStubSigner(t, t.TempDir())
+
+	for _, key := range stubSignerKeys {
+		t.Run(key.name, func(t *testing.T) {
+			priv, err := key.generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keyPath := writeStubSignerKey(t, t.TempDir(), priv)
+
+			checkExtSigner(t, "exec:"+bin+" "+keyPath, priv)
+		})
+	}
+}
+
+func TestExtSignerUnix(t *testing.T) {
+	bin := buildStubSigner(t, t.TempDir())
+
+	for _, key := range stubSignerKeys {
+		t.Run(key.name, func(t *testing.T) {
+			priv, err := key.generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			keyPath := writeStubSignerKey(t, dir, priv)
+			socketPath := filepath.Join(dir, "signer.sock")
+
+			cmd := exec.Command(bin, "-listen", socketPath, keyPath)
+			cmd.Stderr = os.Stderr
+			err = cmd.Start()
+			if err != nil {
+				t.Fatalf("Failed to start stubsigner: %v", err)
+			}
+			t.Cleanup(func() {
+				_ = cmd.Process.Kill()
+				_ = cmd.Wait()
+			})
+
+			for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+				if _, err := os.Stat(socketPath); err == nil {
+					break
+				}
+				if time.Since(start) > 10*time.Second {
+					t.Fatalf("stubsigner didn't create %s", socketPath)
+				}
+			}
+
+			checkExtSigner(t, "unix:"+socketPath, priv)
+		})
+	}
+}
+
+func TestIsExtSignerPath(t *testing.T) {
+	tests := map[string]bool{
+		"exec:/usr/bin/signer": true,
+		"unix:/run/signer":     true,
+		"caKey.pem":            false,
+		"pkcs11:token=foo":     false,
+		"ssh-agent:":           false,
+	}
+
+	for path, want := range tests {
+		if got := isExtSignerPath(path); got != want {
+			t.Errorf("isExtSignerPath(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
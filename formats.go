@@ -0,0 +1,67 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// checkOutputFormats validates the -output-formats flag.
+func checkOutputFormats() error {
+	if *outputFormats == "" {
+		return nil
+	}
+
+	for _, format := range strings.Split(*outputFormats, ",") {
+		switch format {
+		case "der", "fullchain", "combined":
+		default:
+			return fmt.Errorf("unrecognized output format %q", format)
+		}
+	}
+
+	return nil
+}
+
+// writeOutputFormats stages the extra output formats requested by
+// -output-formats, based on the chain staged by writeChain.  privPEM is the
+// end-entity key as written to key.pem.
+func writeOutputFormats(privPEM []byte) {
+	if *outputFormats == "" {
+		return
+	}
+
+	certs, err := stagedCertChain("chain.pem")
+	if err != nil {
+		log.Fatalf("Failed to parse chain.pem: %v", err)
+	}
+
+	for _, format := range strings.Split(*outputFormats, ",") {
+		switch format {
+		case "der":
+			// One file per tier: cert.der, caCert.der, then caCert2.der
+			// etc. for any further CAs in the chain.
+			for i, cert := range certs {
+				var name string
+				switch i {
+				case 0:
+					name = "cert.der"
+				case 1:
+					name = "caCert.der"
+				default:
+					name = fmt.Sprintf("caCert%d.der", i)
+				}
+
+				stageArtifact(name, cert.Raw, 0644)
+			}
+		case "fullchain":
+			stageArtifact("fullchain.pem", encodeFullChain(certs), 0644)
+		case "combined":
+			stageArtifact("combined.pem", append(encodeFullChain(certs), privPEM...), 0600)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestWriteOutputFormats(t *testing.T) {
+	priv, certs := stageTestChain(t)
+
+	oldFormats := *outputFormats
+	*outputFormats = "der,fullchain,combined"
+	t.Cleanup(func() { *outputFormats = oldFormats })
+
+	privPEM, err := marshalPrivateKeyPEM(priv, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeOutputFormats(privPEM)
+
+	for name, cert := range map[string]*x509.Certificate{"cert.der": certs[0], "caCert.der": certs[1]} {
+		der, ok := stagedArtifact(name)
+		if !ok {
+			t.Errorf("%s was not staged", name)
+			continue
+		}
+
+		if !bytes.Equal(der, cert.Raw) {
+			t.Errorf("%s doesn't hold the expected cert", name)
+		}
+	}
+
+	fullchain, ok := stagedArtifact("fullchain.pem")
+	if !ok {
+		t.Fatal("fullchain.pem was not staged")
+	}
+
+	if bytes.Contains(fullchain, []byte("\n\n")) {
+		t.Error("fullchain.pem has blank lines between certs")
+	}
+
+	fullchainCerts, err := parseCertChain(fullchain)
+	if err != nil {
+		t.Fatalf("Failed to parse fullchain.pem: %v", err)
+	}
+	checkSameCerts(t, "fullchain.pem", fullchainCerts, certs)
+
+	combined, ok := stagedArtifact("combined.pem")
+	if !ok {
+		t.Fatal("combined.pem was not staged")
+	}
+
+	var combinedCerts []*x509.Certificate
+	var keyBlock *pem.Block
+	for rest := combined; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			if len(bytes.TrimSpace(rest)) != 0 {
+				t.Error("combined.pem has trailing data")
+			}
+			break
+		}
+
+		switch {
+		case block.Type == "CERTIFICATE" && keyBlock == nil:
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			combinedCerts = append(combinedCerts, cert)
+		case block.Type == "PRIVATE KEY" && keyBlock == nil:
+			keyBlock = block
+		default:
+			t.Errorf("unexpected %s block in combined.pem", block.Type)
+		}
+	}
+
+	checkSameCerts(t, "combined.pem", combinedCerts, certs)
+
+	if keyBlock == nil {
+		t.Fatal("combined.pem has no private key")
+	}
+
+	combinedKey, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !priv.Equal(combinedKey) {
+		t.Error("combined.pem holds the wrong private key")
+	}
+}
+
+func checkSameCerts(t *testing.T, name string, got, want []*x509.Certificate) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("%s holds %d certs, want %d", name, len(got), len(want))
+		return
+	}
+
+	for i := range got {
+		if !bytes.Equal(got[i].Raw, want[i].Raw) {
+			t.Errorf("%s cert %d doesn't match chain.pem", name, i)
+		}
+	}
+}
+
+func TestCheckOutputFormats(t *testing.T) {
+	oldFormats := *outputFormats
+	t.Cleanup(func() { *outputFormats = oldFormats })
+
+	for formats, valid := range map[string]bool{
+		"":                       true,
+		"der":                    true,
+		"der,fullchain,combined": true,
+		"pem":                    false,
+		"der,":                   false,
+	} {
+		*outputFormats = formats
+
+		err := checkOutputFormats()
+		if valid && err != nil {
+			t.Errorf("checkOutputFormats(%q): %v", formats, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("checkOutputFormats(%q) succeeded; expected an error", formats)
+		}
+	}
+}
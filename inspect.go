@@ -0,0 +1,145 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// inspectMain implements "ncgencert inspect", which shows the stapled data
+// carried by the certs in cert chain files, and checks that each stapled
+// public key really did sign the cert that carries it.
+func inspectMain(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s inspect CHAIN...\n\n", os.Args[0])
+		fmt.Fprintf(flags.Output(), "Shows and verifies the stapled data in the given PEM cert chains.\n")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(args)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ok := true
+	for _, path := range flags.Args() {
+		certs, err := readCertChain(path)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+
+		for i, cert := range certs {
+			fmt.Printf("%s: cert %d: %q\n", path, i, cert.Subject.CommonName)
+
+			if !inspectCert(cert) {
+				ok = false
+			}
+		}
+	}
+
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// inspectCert prints the stapled data in cert's Subject, Issuer and stapled
+// data extension, and returns false if any of it is invalid.
+func inspectCert(cert *x509.Certificate) bool {
+	ok := true
+
+	subjectStapled, err := parseStapledData(cert.Subject.SerialNumber)
+	switch {
+	case errors.Is(err, errNoStapledData):
+	case err != nil:
+		fmt.Printf("  Subject stapled data: INVALID: %v\n", err)
+		ok = false
+	default:
+		printStapledData("Subject", subjectStapled)
+	}
+
+	// Both encodings describe the issuer.
+	sources := []struct {
+		name  string
+		parse func() (map[string]string, error)
+	}{
+		{"Issuer", func() (map[string]string, error) { return parseStapledData(cert.Issuer.SerialNumber) }},
+		{"Extension", func() (map[string]string, error) { return parseStapledExtension(cert) }},
+	}
+
+	for _, source := range sources {
+		stapled, err := source.parse()
+		switch {
+		case errors.Is(err, errNoStapledData):
+			continue
+		case err != nil:
+			fmt.Printf("  %s stapled data: INVALID: %v\n", source.name, err)
+			ok = false
+			continue
+		}
+
+		printStapledData(source.name, stapled)
+
+		// The issuer's stapled key is what AIA clients will be served, so
+		// it had better be the key that signed this cert.
+		err = checkStapledSignature(cert, stapled[stapledKeyPubB64])
+		if err != nil {
+			fmt.Printf("  %s stapled %s: DOES NOT MATCH: %v\n", source.name, stapledKeyPubB64, err)
+			ok = false
+			continue
+		}
+
+		fmt.Printf("  %s stapled %s: matches signature\n", source.name, stapledKeyPubB64)
+	}
+
+	return ok
+}
+
+func printStapledData(which string, stapled map[string]string) {
+	// parseStapledData fills in the implied version.
+	fmt.Printf("  %s stapled data (v%s):\n", which, stapled[stapledKeyVersion])
+
+	keys := make([]string, 0, len(stapled))
+	for k := range stapled {
+		if k != stapledKeyVersion {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		fmt.Printf("    %s: %s\n", k, stapled[k])
+	}
+}
+
+func checkStapledSignature(cert *x509.Certificate, pubB64 string) error {
+	pubBytes, err := base64.RawURLEncoding.DecodeString(pubB64)
+	if err != nil {
+		return err
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(pubBytes)
+	if err != nil {
+		return err
+	}
+
+	// CheckSignature checks against the receiver's public key, so a bare
+	// cert holding the stapled key is enough.
+	issuer := &x509.Certificate{PublicKey: pub}
+
+	return issuer.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+}
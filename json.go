@@ -8,7 +8,6 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/json"
-	"io/ioutil"
 	"log"
 )
 
@@ -32,10 +31,5 @@ func writeJSONTLSA(priv any) {
 		log.Fatalf("Failed to marshal Namecoin record: %v", err)
 	}
 
-	err = ioutil.WriteFile("namecoin.json", tlsaBytes, 0600)
-	if err != nil {
-		log.Fatalf("Failed to write data to namecoin.json: %v", err)
-	}
-
-	log.Print("wrote namecoin.json")
+	stageArtifact("namecoin.json", tlsaBytes, 0600)
 }
@@ -0,0 +1,250 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+	"golang.org/x/crypto/ssh"
+)
+
+// generatePrivateKey generates a key of the type selected by the
+// -ecdsa-curve and -ed25519 flags.  The main, parent, and aiaparent flows
+// carry their own copy of this logic so that they can be rebased against
+// upstream Go; new flows should use this instead.
+func generatePrivateKey() (any, error) {
+	if *ed25519Key {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	}
+
+	switch *ecdsaCurve {
+	case "":
+		return nil, fmt.Errorf("missing required --ecdsa-curve or --ed25519 parameter")
+	case "P224":
+		return ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	case "P256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "P384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "P521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unrecognized elliptic curve: %q", *ecdsaCurve)
+	}
+}
+
+// supportedKeyFormats is listed in errors when a private key file can't be
+// parsed.
+const supportedKeyFormats = "PKCS#8 (\"PRIVATE KEY\" or \"ENCRYPTED PRIVATE KEY\"), SEC1 (\"EC PRIVATE KEY\"), PKCS#1 (\"RSA PRIVATE KEY\") and OpenSSH (\"OPENSSH PRIVATE KEY\")"
+
+// readPrivateKey reads a PEM private key file, or loads a signer from a
+// PKCS#11 URI, ssh-agent or external signer.  Encrypted key files are
+// decrypted with the passphrase from passphrase.
+func readPrivateKey(path string, passphrase *passphraseSource) (any, error) {
+	if strings.HasPrefix(path, "pkcs11:") {
+		if *pkcs11Generate && *dryRun {
+			// Don't leave keys behind on the token; a throwaway
+			// software key is enough to preview the hierarchy.
+			log.Print("Dry run: using a temporary software key instead of generating one on the token")
+			return generatePrivateKey()
+		}
+
+		return loadPKCS11Signer(path, *pkcs11Generate)
+	}
+
+	if strings.HasPrefix(path, "ssh-agent:") {
+		return loadSSHAgentSigner(path)
+	}
+
+	if isExtSignerPath(path) {
+		return loadExtSigner(path)
+	}
+
+	privPEM, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	privBlock, rest := pem.Decode(privPEM)
+	// OpenSSL's "ecparam -genkey" emits the curve parameters before the key.
+	if privBlock != nil && strings.HasSuffix(privBlock.Type, " PARAMETERS") {
+		privBlock, rest = pem.Decode(rest)
+	}
+	if privBlock == nil {
+		return nil, fmt.Errorf("%s: no PEM private key found; supported formats are %s", path, supportedKeyFormats)
+	}
+	if len(bytes.TrimSpace(rest)) != 0 {
+		return nil, fmt.Errorf("%s: unexpected data after private key", path)
+	}
+	if _, ok := privBlock.Headers["DEK-Info"]; ok {
+		return nil, fmt.Errorf("%s: legacy OpenSSL PEM encryption is not supported; convert the key to encrypted PKCS#8 with \"openssl pkcs8 -topk8\"", path)
+	}
+
+	var priv any
+	switch privBlock.Type {
+	case "PRIVATE KEY":
+		priv, err = x509.ParsePKCS8PrivateKey(privBlock.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		var passphraseBytes []byte
+		passphraseBytes, err = passphrase.get()
+		if err != nil {
+			return nil, fmt.Errorf("%s is encrypted: %w", path, err)
+		}
+		priv, _, err = pkcs8.ParsePrivateKey(privBlock.Bytes, passphraseBytes)
+	case "EC PRIVATE KEY":
+		priv, err = x509.ParseECPrivateKey(privBlock.Bytes)
+	case "RSA PRIVATE KEY":
+		priv, err = x509.ParsePKCS1PrivateKey(privBlock.Bytes)
+	case "OPENSSH PRIVATE KEY":
+		priv, err = ssh.ParseRawPrivateKey(privPEM)
+
+		var missingErr *ssh.PassphraseMissingError
+		if errors.As(err, &missingErr) {
+			var passphraseBytes []byte
+			passphraseBytes, err = passphrase.get()
+			if err != nil {
+				return nil, fmt.Errorf("%s is encrypted: %w", path, err)
+			}
+			priv, err = ssh.ParseRawPrivateKeyWithPassphrase(privPEM, passphraseBytes)
+		}
+
+		// x509 and the rest of ncgencert expect Ed25519 keys by value.
+		if edPriv, ok := priv.(*ed25519.PrivateKey); ok {
+			priv = *edPriv
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported %q PEM block; supported formats are %s", path, privBlock.Type, supportedKeyFormats)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if publicKey(priv) == nil {
+		return nil, fmt.Errorf("%s: unsupported private key type %T", path, priv)
+	}
+
+	return priv, nil
+}
+
+// encryptCAKeys returns true if -encrypt-keys requires CA private keys to be
+// encrypted.
+func encryptCAKeys() bool {
+	// Dry runs write nothing, so there's no point prompting for a
+	// passphrase.
+	if *dryRun {
+		return false
+	}
+
+	return *encryptKeys == "ca" || *encryptKeys == "all"
+}
+
+// encryptLeafKeys returns true if -encrypt-keys requires end-entity private
+// keys to be encrypted.
+func encryptLeafKeys() bool {
+	if *dryRun {
+		return false
+	}
+
+	return *encryptKeys == "all"
+}
+
+// marshalPrivateKeyPEM encodes priv as PKCS#8 PEM.  If encrypt is set, the
+// key is encrypted with PBES2, using the -key-kdf key derivation function and
+// the -key-cipher cipher.
+func marshalPrivateKeyPEM(priv any, encrypt bool) ([]byte, error) {
+	if !encrypt {
+		privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+		if err != nil {
+			return nil, err
+		}
+
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), nil
+	}
+
+	passphrase, err := encryptPassphrase.get()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &pkcs8.Opts{}
+
+	switch *keyCipher {
+	case "aes-256-gcm":
+		opts.Cipher = pkcs8.AES256GCM
+	case "aes-256-cbc":
+		opts.Cipher = pkcs8.AES256CBC
+	default:
+		return nil, fmt.Errorf("unrecognized key cipher: %q", *keyCipher)
+	}
+
+	switch *keyKDF {
+	case "scrypt":
+		opts.KDFOpts = pkcs8.ScryptOpts{
+			SaltSize:                 16,
+			CostParameter:            1 << 17,
+			BlockSize:                8,
+			ParallelizationParameter: 1,
+		}
+	case "pbkdf2":
+		opts.KDFOpts = pkcs8.PBKDF2Opts{
+			SaltSize:       16,
+			IterationCount: 600000,
+			HMACHash:       crypto.SHA256,
+		}
+	default:
+		return nil, fmt.Errorf("unrecognized key derivation function: %q", *keyKDF)
+	}
+
+	privBytes, err := pkcs8.MarshalPrivateKey(priv, passphrase, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: privBytes}), nil
+}
+
+// checkKeyMatchesCert returns an error unless priv is the private key for
+// cert's public key.
+func checkKeyMatchesCert(priv any, cert *x509.Certificate) error {
+	pub, ok := publicKey(priv).(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return fmt.Errorf("unsupported private key type %T", priv)
+	}
+
+	if !pub.Equal(cert.PublicKey) {
+		return fmt.Errorf("private key (public key SHA-256 %s) does not match the public key of %q (SHA-256 %s)", spkiFingerprint(pub), cert.Subject.CommonName, spkiFingerprint(cert.PublicKey))
+	}
+
+	return nil
+}
+
+// spkiFingerprint returns the hex SHA-256 of a public key's
+// SubjectPublicKeyInfo, for use in error messages.
+func spkiFingerprint(pub any) string {
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "unknown"
+	}
+
+	pubHash := sha256.Sum256(pubBytes)
+
+	return hex.EncodeToString(pubHash[:])
+}
@@ -20,6 +20,7 @@
 package main
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
@@ -40,18 +41,51 @@ import (
 var (
 	//host       = flag.String("host", "", "Comma-separated hostnames and IPs to generate a certificate for")
 	host       = flag.String("host", "", "Comma-separated hostnames to generate a certificate for (only use one unless -parent-chain or -grandparent-chain is set)")
-	validFrom  = flag.String("start-date", "", "Creation date formatted as Jan 1 15:04:05 2011")
-	validFor   = flag.Duration("duration", 365*24*time.Hour, "Duration that certificate is valid for")
+	//validFrom  = flag.String("start-date", "", "Creation date formatted as Jan 1 15:04:05 2011")
+	validFrom  = flag.String("start-date", "", "Creation date: "+startDateFormats+" (default now)")
+	timeZone   = flag.String("time-zone", "UTC", "Time zone (e.g. UTC, Local or America/New_York) for -start-date values that don't include one")
+	//validFor   = flag.Duration("duration", 365*24*time.Hour, "Duration that certificate is valid for")
+	validFor   = flag.Duration("duration", 365*24*time.Hour, "Duration that the end-entity certificate is valid for")
+	caValidFor = flag.Duration("ca-duration", 0, "Duration that the domain CA (or -delegate CA) is valid for (default -duration)")
+	aiaValidFor = flag.Duration("aia-duration", 0, "Duration that the AIA parent CA is valid for (default -ca-duration)")
+	backdate   = flag.Duration("backdate", 0, "How far to backdate the end-entity certificate's NotBefore, to tolerate clients with slow clocks")
+	caBackdate = flag.Duration("ca-backdate", 0, "How far to backdate the domain CA's (or -delegate CA's) NotBefore (default -backdate)")
+	aiaBackdate = flag.Duration("aia-backdate", 0, "How far to backdate the AIA parent CA's NotBefore (default -ca-backdate)")
+	timestampPrecision = flag.Duration("timestamp-precision", 5*time.Minute, "Round every tier's NotBefore and NotAfter down to a multiple of this (e.g. 24h), so they don't reveal when the certs were generated")
+	timestampJitter = flag.Duration("timestamp-jitter", 0, "(Optional) Move the start date back by a random amount up to this (e.g. 72h), so issuance can't be correlated with blockchain transactions")
 	//isCA       = flag.Bool("ca", false, "whether this cert should be its own Certificate Authority")
 	//rsaBits    = flag.Int("rsa-bits", 2048, "Size of RSA key to generate. Ignored if --ecdsa-curve is set")
 	//ecdsaCurve = flag.String("ecdsa-curve", "", "ECDSA curve to use to generate a key. Valid values are P224, P256 (recommended), P384, P521")
 	ecdsaCurve = flag.String("ecdsa-curve", "P256", "ECDSA curve to use to generate a key. Valid values are P224, P256 (default), P384, P521")
 	ed25519Key = flag.Bool("ed25519", false, "Generate an Ed25519 key")
-	parentKey  = flag.String("parent-key", "", "(Optional) Path to existing CA private key to sign end-entity cert with")
+	parentKey  = flag.String("parent-key", "", "(Optional) Path to existing CA private key (or PKCS#11 URI, ssh-agent:SELECTOR, or exec: or unix: external signer) to sign end-entity cert with")
 	parentChain = flag.String("parent-chain", "", "(Optional) Path to existing CA cert chain to sign end-entity cert with")
-	grandparentKey  = flag.String("grandparent-key", "", "(Optional) Path to existing CA private key to sign CA cert with")
+	grandparentKey  = flag.String("grandparent-key", "", "(Optional) Path to existing CA private key (or PKCS#11 URI, ssh-agent:SELECTOR, or exec: or unix: external signer) to sign CA cert with")
 	grandparentChain = flag.String("grandparent-chain", "", "(Optional) Path to existing CA cert chain to sign CA cert with")
 	sigs = flag.String("sigs", "", "(Optional) Path to existing Namecoin message signatures to staple (saves blockchain space)")
+	encryptKeys = flag.String("encrypt-keys", "", "Encrypt generated private keys with a passphrase: \"ca\" (caKey.pem and caAIAKey.pem) or \"all\" (also key.pem)")
+	keyKDF = flag.String("key-kdf", "scrypt", "Key derivation function for -encrypt-keys: scrypt (default) or pbkdf2")
+	keyCipher = flag.String("key-cipher", "aes-256-gcm", "Cipher for -encrypt-keys: aes-256-gcm (default) or aes-256-cbc (for compatibility with OpenSSL)")
+	passphraseEnv = flag.String("passphrase-env", "", "(Optional) Name of an environment variable holding the passphrase to encrypt generated private keys with")
+	passphraseFD = flag.Int("passphrase-fd", -1, "(Optional) File descriptor to read the passphrase to encrypt generated private keys with from (otherwise it is prompted for on the terminal)")
+	parentKeyPassphraseEnv = flag.String("parent-key-passphrase-env", "", "(Optional) Name of an environment variable holding the passphrase of an encrypted -parent-key")
+	parentKeyPassphraseFD = flag.Int("parent-key-passphrase-fd", -1, "(Optional) File descriptor to read the passphrase of an encrypted -parent-key from (otherwise it is prompted for on the terminal)")
+	grandparentKeyPassphraseEnv = flag.String("grandparent-key-passphrase-env", "", "(Optional) Name of an environment variable holding the passphrase of an encrypted -grandparent-key")
+	grandparentKeyPassphraseFD = flag.Int("grandparent-key-passphrase-fd", -1, "(Optional) File descriptor to read the passphrase of an encrypted -grandparent-key from (otherwise it is prompted for on the terminal)")
+	pkcs11Module = flag.String("pkcs11-module", "", "(Optional) Path to the PKCS#11 module to use for -parent-key and -grandparent-key URIs that don't specify a module-path")
+	pkcs11Generate = flag.Bool("pkcs11-generate", false, "Generate new key pairs on the token for -parent-key and -grandparent-key PKCS#11 URIs instead of using existing keys")
+	pkcs12Out = flag.Bool("pkcs12", false, "Also write the end-entity key, cert and chain to cert.p12")
+	pkcs12PassphraseEnv = flag.String("pkcs12-passphrase-env", "", "(Optional) Name of an environment variable holding the cert.p12 passphrase")
+	pkcs12PassphraseFD = flag.Int("pkcs12-passphrase-fd", -1, "(Optional) File descriptor to read the cert.p12 passphrase from (otherwise it is prompted for on the terminal)")
+	outputFormats = flag.String("output-formats", "", "(Optional) Comma-separated extra output formats: der (cert.der, caCert.der, ...), fullchain (fullchain.pem, without padding) and combined (combined.pem, the full chain followed by the key)")
+	caPathLen = flag.Int("ca-path-len", 0, "Path length constraint for the domain CA (0 means it can only issue end-entity certs; use 1 or more to allow -delegate; -1 means unlimited)")
+	delegate = flag.Bool("delegate", false, "Issue an intermediate CA constrained to -host (a subdomain of the -parent-chain CA) instead of an end-entity cert; writes caCert.pem, caKey.pem and caChain.pem")
+	delegatePathLen = flag.Int("delegate-path-len", 0, "Path length constraint for the -delegate CA (0 means it can only issue end-entity certs)")
+	configFile = flag.String("config", "", "(Optional) Path to a TOML (.toml) or YAML (.yaml, .yml) file of flag settings, e.g. host = \"example.bit\"; flags given on the command line take precedence")
+	stapledEncoding = flag.String("stapled-encoding", stapledEncodingSerial, "Where to put the AIA parent's stapled data: \"serial\" (its Subject Serial Number), or, experimentally, \"both\" (also in a non-critical extension in the domain CA, under a provisional OID)")
+	sigsEncoding = flag.String("sigs-encoding", sigsEncodingRaw, "How to put -sigs in the AIA URL: \"raw\" (the file contents, as the sigs parameter) or \"compact\" (deduplicated binary signatures as base64url, as the sigsb64 parameter)")
+	metaDomains = flag.String("meta-domains", "", "(Optional) Comma-separated meta-domain handlers to enable, for Encaya conformance testing, e.g. \"pi\"")
+	dryRun = flag.Bool("dry-run", false, "Show the certificates and TLSA record that would be generated, and the files that would be created or overwritten, without writing anything")
 	useAIA bool
 )
 
@@ -63,25 +97,117 @@ func publicKey(priv any) any {
 		return &k.PublicKey
 	case ed25519.PrivateKey:
 		return k.Public().(ed25519.PublicKey)
+	case crypto.Signer:
+		return k.Public()
 	default:
 		return nil
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-expiry":
+			checkExpiryMain(os.Args[2:])
+			return
+		case "inspect":
+			inspectMain(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
+	err := loadEnvironment()
+	if err != nil {
+		log.Fatalf("Failed to load settings from environment: %v", err)
+	}
+
+	if *configFile != "" {
+		err = loadConfigFile(*configFile)
+		if err != nil {
+			log.Fatalf("Failed to load config file %s: %v", *configFile, err)
+		}
+	}
+
 	if len(*host) == 0 {
 		log.Fatalf("Missing required --host parameter")
 	}
 
+	switch *encryptKeys {
+	case "", "ca", "all":
+	default:
+		log.Fatalf("Unrecognized -encrypt-keys value: %q", *encryptKeys)
+	}
+
+	if err := checkOutputFormats(); err != nil {
+		log.Fatalf("Invalid -output-formats: %v", err)
+	}
+
+	switch *stapledEncoding {
+	case stapledEncodingSerial:
+	case stapledEncodingExtension:
+		// Encaya only rebuilds the AIA parent with the stapled data in its
+		// Subject, so without it in the domain CA's Issuer nothing chains.
+		log.Fatalf("-stapled-encoding %s is not supported until Encaya supports it; use %q or %q", *stapledEncoding, stapledEncodingSerial, stapledEncodingBoth)
+	case stapledEncodingBoth:
+		log.Printf("WARNING: -stapled-encoding %s is experimental, and its OID %s is a placeholder", *stapledEncoding, oidStapledData)
+	default:
+		log.Fatalf("Unrecognized -stapled-encoding value: %q", *stapledEncoding)
+	}
+
+	switch *sigsEncoding {
+	case sigsEncodingRaw, sigsEncodingCompact:
+	default:
+		log.Fatalf("Unrecognized -sigs-encoding value: %q", *sigsEncoding)
+	}
+
+	if _, err := enabledMetaDomainHandlers(); err != nil {
+		log.Fatalf("Invalid -meta-domains: %v", err)
+	}
+
+	// Meta-domain data goes in the AIA URL, which only has one domain
+	// parameter.
+	if *metaDomains != "" && strings.Contains(*host, ",") {
+		log.Fatalf("-meta-domains requires a single -host")
+	}
+
+	if *caPathLen < -1 {
+		log.Fatalf("Invalid -ca-path-len: %d", *caPathLen)
+	}
+
+	if *validFor <= 0 || *caValidFor < 0 || *aiaValidFor < 0 {
+		log.Fatalf("-duration, -ca-duration and -aia-duration must be positive")
+	}
+
+	if *backdate < 0 || *caBackdate < 0 || *aiaBackdate < 0 {
+		log.Fatalf("-backdate, -ca-backdate and -aia-backdate can't be negative")
+	}
+
+	if *timestampPrecision < time.Second {
+		log.Fatalf("-timestamp-precision must be at least 1s")
+	}
+
+	if *timestampJitter < 0 {
+		log.Fatalf("-timestamp-jitter can't be negative")
+	}
+
+	// Parse -start-date before generating any keys, so that mistakes are
+	// reported early.
+	startDate()
+
 	useAIA = *parentChain == "" && *grandparentChain == ""
 
 	var priv any
-	var err error
 	if *ed25519Key {
 		*ecdsaCurve = ""
 	}
+
+	if *delegate {
+		writeDelegatedCA()
+		return
+	}
+
 	switch *ecdsaCurve {
 	case "":
 		if *ed25519Key {
@@ -115,22 +241,27 @@ func main() {
 	//	keyUsage |= x509.KeyUsageKeyEncipherment
 	//}
 
-	var notBefore time.Time
-	if len(*validFrom) == 0 {
-		notBefore = time.Now()
-	} else {
-		notBefore, err = time.Parse("Jan 2 15:04:05 2006", *validFrom)
-		if err != nil {
-			log.Fatalf("Failed to parse creation date: %v", err)
-		}
-	}
+	//var notBefore time.Time
+	//if len(*validFrom) == 0 {
+	//	notBefore = time.Now()
+	//} else {
+	//	notBefore, err = time.Parse("Jan 2 15:04:05 2006", *validFrom)
+	//	if err != nil {
+	//		log.Fatalf("Failed to parse creation date: %v", err)
+	//	}
+	//}
+	notBefore := startDate()
 
 	notAfter := notBefore.Add(*validFor)
 
-	timestampPrecision := int64(5 * 60)
+	notBefore = notBefore.Add(-*backdate)
 
-	notBeforeFloored := time.Unix((notBefore.Unix()/timestampPrecision)*timestampPrecision, 0)
-	notAfterFloored := time.Unix((notAfter.Unix()/timestampPrecision)*timestampPrecision, 0)
+	//timestampPrecision := int64(5 * 60)
+
+	//notBeforeFloored := time.Unix((notBefore.Unix()/timestampPrecision)*timestampPrecision, 0)
+	//notAfterFloored := time.Unix((notAfter.Unix()/timestampPrecision)*timestampPrecision, 0)
+	notBeforeFloored := floorTimestamp(notBefore)
+	notAfterFloored := floorTimestamp(notAfter)
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -175,48 +306,45 @@ func main() {
 
 	parent, parentPriv = getParent()
 
+	err = checkValidityWithin(&template, &parent)
+	if err != nil {
+		log.Fatalf("Invalid -duration: %v", err)
+	}
+
 	//derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &parent, publicKey(priv), parentPriv)
 	if err != nil {
 		log.Fatalf("Failed to create certificate: %v", err)
 	}
 
-	certOut, err := os.Create("cert.pem")
-	if err != nil {
-		log.Fatalf("Failed to open cert.pem for writing: %v", err)
-	}
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		log.Fatalf("Failed to write data to cert.pem: %v", err)
-	}
-	if err := certOut.Close(); err != nil {
-		log.Fatalf("Error closing cert.pem: %v", err)
-	}
-	log.Print("wrote cert.pem\n")
+	stageArtifact("cert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0644)
 
-	keyOut, err := os.OpenFile("key.pem", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Failed to open key.pem for writing: %v", err)
-		return
-	}
-	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	privPEM, err := marshalPrivateKeyPEM(priv, encryptLeafKeys())
 	if err != nil {
 		log.Fatalf("Unable to marshal private key: %v", err)
 	}
-	if err := pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}); err != nil {
-		log.Fatalf("Failed to write data to key.pem: %v", err)
+	stageArtifact("key.pem", privPEM, 0600)
+
+	writeChain()
+
+	if *pkcs12Out {
+		writePKCS12(priv)
 	}
-	if err := keyOut.Close(); err != nil {
-		log.Fatalf("Error closing key.pem: %v", err)
+
+	writeOutputFormats(privPEM)
+
+	if *dryRun {
+		printDryRun()
+		return
 	}
-	log.Print("wrote key.pem\n")
 
-	writeChain()
+	commitArtifacts()
 
 	if *sigs == "" && *grandparentKey == "" {
 		log.Print("SUCCESS. You have two deployment options.")
 		log.Print("Option 1 (wastes blockchain space): Place chain.pem and key.pem in your HTTPS server, and place the contents of \"namecoin.json\" in the \"tls\" field for \"*." + *host + "\".")
 		log.Print("Option 2 (conserves blockchain space): sign \"caAIAMessage.txt\" with your Namecoin wallet. Then re-run ncgencert with the \"-grandparent-key\" and \"-sigs\" parameters to generate your final certificate chain; no blockchain transaction is necessary.")
 	} else {
-		log.Print("SUCCESS. Place chain.pem and key.pem in your HTTPS server.")
+		log.Print("SUCCESS. Place chain.pem and key.pem (or cert.p12, if requested) in your HTTPS server.")
 	}
 }
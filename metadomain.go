@@ -0,0 +1,118 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Meta-domains are special domains (such as INTEGER.pi.x--nmc.bit) used for
+// Encaya conformance testing, whose certs carry extra data.  Each family of
+// meta-domains has a handler, registered from an init function, which is
+// only used if it's enabled with -meta-domains.
+
+type metaDomainHandler interface {
+	// Matches returns true if domain is one of this handler's
+	// meta-domains.
+	Matches(domain string) bool
+
+	// ApplyAIAParentCA adds data for domain to the AIA parent's stapled
+	// data.
+	ApplyAIAParentCA(domain string, template *x509.Certificate, stapled map[string]string) error
+
+	// ApplyDomainCA adds data for domain to the domain CA, e.g. to its AIA
+	// URL.
+	ApplyDomainCA(domain string, template *x509.Certificate) error
+}
+
+var metaDomainHandlers = map[string]metaDomainHandler{}
+
+// registerMetaDomainHandler makes a handler available to -meta-domains under
+// name.  It must only be called from init functions.
+func registerMetaDomainHandler(name string, handler metaDomainHandler) {
+	if _, ok := metaDomainHandlers[name]; ok {
+		panic("meta-domain handler registered twice: " + name)
+	}
+
+	metaDomainHandlers[name] = handler
+}
+
+func metaDomainHandlerNames() []string {
+	names := make([]string, 0, len(metaDomainHandlers))
+	for name := range metaDomainHandlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// enabledMetaDomainHandlers returns the handlers selected by -meta-domains.
+func enabledMetaDomainHandlers() ([]metaDomainHandler, error) {
+	if *metaDomains == "" {
+		return nil, nil
+	}
+
+	var handlers []metaDomainHandler
+	for _, name := range strings.Split(*metaDomains, ",") {
+		handler, ok := metaDomainHandlers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown meta-domain handler %q (available: %s)", name, strings.Join(metaDomainHandlerNames(), ", "))
+		}
+
+		handlers = append(handlers, handler)
+	}
+
+	return handlers, nil
+}
+
+// metaDomainHandlerFor returns the enabled handler for domain, or nil if
+// there isn't one.
+func metaDomainHandlerFor(domain string) metaDomainHandler {
+	handlers, err := enabledMetaDomainHandlers()
+	if err != nil {
+		log.Fatalf("Invalid -meta-domains: %v", err)
+	}
+
+	for _, handler := range handlers {
+		if handler.Matches(domain) {
+			return handler
+		}
+	}
+
+	return nil
+}
+
+// applyMetaDomainAIAParentCA and applyMetaDomainCA must be given the same
+// domain: the AIA URL's domain parameter, from which Encaya rebuilds the AIA
+// parent.
+
+func applyMetaDomainAIAParentCA(domain string, template *x509.Certificate, stapled map[string]string) {
+	handler := metaDomainHandlerFor(domain)
+	if handler == nil {
+		return
+	}
+
+	err := handler.ApplyAIAParentCA(domain, template, stapled)
+	if err != nil {
+		log.Fatalf("Invalid meta-domain %s: %v", domain, err)
+	}
+}
+
+func applyMetaDomainCA(domain string, template *x509.Certificate) {
+	handler := metaDomainHandlerFor(domain)
+	if handler == nil {
+		return
+	}
+
+	err := handler.ApplyDomainCA(domain, template)
+	if err != nil {
+		log.Fatalf("Invalid meta-domain %s: %v", domain, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ferhatelmas/pi"
+)
+
+// Pi meta-domains are of the form INTEGER.pi.x--nmc.bit, and staple the
+// first INTEGER digits of pi.
+const piMetaSuffix = ".pi.x--nmc.bit"
+
+// piURLReserve is the part of the AIA URL reserved for everything but the
+// digits: the base URL, the domain and an ECDSA pubb64 take about 200 bytes.
+const piURLReserve = 512
+
+// piMaxDigits bounds the digit count, since the digits have to fit in the
+// AIA URL.  Larger keys or stapled sigs can still push the URL over the
+// limit, which ApplyDomainCA checks for.
+const piMaxDigits = strictestURLLimit - piURLReserve
+
+type piMetaDomain struct {
+	// digitsCache makes sure the AIA parent and the domain CA are given
+	// the very same digits.
+	digitsCache map[string]string
+}
+
+func init() {
+	registerMetaDomainHandler("pi", &piMetaDomain{digitsCache: map[string]string{}})
+}
+
+func (p *piMetaDomain) Matches(domain string) bool {
+	return strings.HasSuffix(domain, piMetaSuffix)
+}
+
+func (p *piMetaDomain) digits(domain string) (string, error) {
+	if digits, ok := p.digitsCache[domain]; ok {
+		return digits, nil
+	}
+
+	digitCountStr := strings.TrimSuffix(domain, piMetaSuffix)
+
+	digitCount, err := strconv.ParseInt(digitCountStr, 10, 0)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a digit count", digitCountStr)
+	}
+
+	// Reject e.g. "+10" and "010", which would otherwise alias "10".
+	if strconv.FormatInt(digitCount, 10) != digitCountStr {
+		return "", fmt.Errorf("%q is not a canonical digit count", digitCountStr)
+	}
+
+	if digitCount < 1 || digitCount > piMaxDigits {
+		return "", fmt.Errorf("digit count %d is out of range (1 to %d)", digitCount, piMaxDigits)
+	}
+
+	digits := pi.Digits(digitCount)
+
+	p.digitsCache[domain] = digits
+
+	return digits, nil
+}
+
+func (p *piMetaDomain) ApplyAIAParentCA(domain string, template *x509.Certificate, stapled map[string]string) error {
+	actualDigits, err := p.digits(domain)
+	if err != nil {
+		return err
+	}
+
+	stapled[stapledKeyPiDigits] = actualDigits
+
+	return nil
+}
+
+func (p *piMetaDomain) ApplyDomainCA(domain string, template *x509.Certificate) error {
+	actualDigits, err := p.digits(domain)
+	if err != nil {
+		return err
+	}
+
+	aiaURL := template.IssuingCertificateURL[0] + "&pidigits=" + actualDigits
+	if len(aiaURL) > strictestURLLimit {
+		return fmt.Errorf("AIA URL with %d digits of pi is %d bytes, longer than %d; use fewer digits", len(actualDigits), len(aiaURL), strictestURLLimit)
+	}
+
+	template.IssuingCertificateURL[0] = aiaURL
+
+	return nil
+}
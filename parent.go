@@ -35,9 +35,9 @@ import (
 	"math/big"
 	//"net"
 	"net/url"
-	"os"
+	//"os"
 	"strings"
-	"time"
+	//"time"
 )
 
 //var (
@@ -97,34 +97,34 @@ func getParent() (x509.Certificate, any) {
 		log.Fatalf("Failed to generate private key: %v", err)
 	}
 
-	var privPEM []byte
 	if *parentKey != "" {
 		log.Print("Using existing CA private key")
-		privPEM, err = ioutil.ReadFile(*parentKey)
+		priv, err = readPrivateKey(*parentKey, parentKeyPassphrase)
 		if err != nil {
 			log.Fatalf("Failed to read private key: %v", err)
 		}
-		privBlock, _ := pem.Decode(privPEM)
-		priv, err = x509.ParsePKCS8PrivateKey(privBlock.Bytes)
-		if err != nil {
-			log.Fatalf("Failed to parse private key: %v", err)
-		}
 	}
 
-	var chainPEM []byte
 	if *parentChain != "" {
 		log.Print("Using existing CA cert chain")
-		chainPEM, err = ioutil.ReadFile(*parentChain)
+		chainCerts, err := readCertChain(*parentChain)
 		if err != nil {
 			log.Fatalf("Failed to read cert chain: %v", err)
 		}
-		chainBlock, _ := pem.Decode(chainPEM)
-		parsedCert, err := x509.ParseCertificate(chainBlock.Bytes)
+
+		if *parentKey == "" {
+			log.Fatalf("-parent-chain requires -parent-key")
+		}
+		err = checkKeyMatchesCert(priv, chainCerts[0])
 		if err != nil {
-			log.Fatalf("Failed to parse cert chain: %v", err)
+			log.Fatalf("-parent-key does not match -parent-chain: %v", err)
+		}
+		err = checkIssuer(chainCerts[0])
+		if err != nil {
+			log.Fatalf("-parent-chain can't be used to sign: %v", err)
 		}
 
-		return *parsedCert, priv
+		return *chainCerts[0], priv
 	}
 
 	// ECDSA, ED25519 and RSA subject keys should have the DigitalSignature
@@ -138,17 +138,21 @@ func getParent() (x509.Certificate, any) {
 	//	keyUsage |= x509.KeyUsageKeyEncipherment
 	//}
 
-	var notBefore time.Time
-	if len(*validFrom) == 0 {
-		notBefore = time.Now()
-	} else {
-		notBefore, err = time.Parse("Jan 2 15:04:05 2006", *validFrom)
-		if err != nil {
-			log.Fatalf("Failed to parse creation date: %v", err)
-		}
-	}
+	//var notBefore time.Time
+	//if len(*validFrom) == 0 {
+	//	notBefore = time.Now()
+	//} else {
+	//	notBefore, err = time.Parse("Jan 2 15:04:05 2006", *validFrom)
+	//	if err != nil {
+	//		log.Fatalf("Failed to parse creation date: %v", err)
+	//	}
+	//}
+	notBefore := startDate()
+
+	//notAfter := notBefore.Add(*validFor)
+	notAfter := notBefore.Add(caDuration())
 
-	notAfter := notBefore.Add(*validFor)
+	notBefore = notBefore.Add(-caBackdateDuration())
 
 	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
 	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
@@ -163,13 +167,17 @@ func getParent() (x509.Certificate, any) {
 			CommonName:   *host + " Domain CA",
 			SerialNumber: "Namecoin TLS Certificate",
 		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
+		//NotBefore: notBefore,
+		NotBefore: floorTimestamp(notBefore),
+		//NotAfter:  notAfter,
+		NotAfter:  floorTimestamp(notAfter),
 
 		IsCA:                  true,
 		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
+		MaxPathLen:            *caPathLen,
+		MaxPathLenZero:        *caPathLen == 0,
 
 		PermittedDNSDomainsCritical: true,
 	}
@@ -205,7 +213,8 @@ func getParent() (x509.Certificate, any) {
 		// Support only HTTP AIA.  HTTPS is not supported by major TLS clients,
 		// and listing an HTTPS URL can cause them to not chase the HTTP URL.
 		aiaBaseURL := "aia.x--nmc.bit/aia"
-		aiaURL := aiaBaseURL + "?domain=" + url.QueryEscape(*host) + "&pubb64=" + url.QueryEscape(aiaPubStr)
+		//aiaURL := aiaBaseURL + "?domain=" + url.QueryEscape(*host) + "&pubb64=" + url.QueryEscape(aiaPubStr)
+		aiaURL := aiaBaseURL + "?domain=" + url.QueryEscape(*host) + "&pubb64=" + url.QueryEscape(aiaPubStr) + "&" + stapledKeyVersion + "=" + stapledVersion
 
 		// Staple sigs if requested
 		if *sigs != "" {
@@ -214,66 +223,49 @@ func getParent() (x509.Certificate, any) {
 				log.Fatalf("Failed to read stapled sigs: %v", err)
 			}
 
-			aiaURL = aiaURL + "&sigs=" + url.QueryEscape(string(sigsBytes))
+			//aiaURL = aiaURL + "&sigs=" + url.QueryEscape(string(sigsBytes))
+			aiaURL = aiaURL + aiaSigsParam(sigsBytes)
 		}
 
 		template.IssuingCertificateURL = []string{"http://" + aiaURL}
 
-		applyPiDomainCA(&template)
+		applyMetaDomainCA(*host, &template)
+
+		checkAIAURLLength(template.IssuingCertificateURL[0])
 	} else if *grandparentKey != "" {
 		aiaParent, aiaParentPriv = getAIAParent()
 	} else {
 		aiaParent, aiaParentPriv = template, priv
 	}
 
+	// The AIA parent is never written, so the domain CA carries its stapled
+	// data extension, if any.
+	template.ExtraExtensions = append(template.ExtraExtensions, stapledExtensions(&aiaParent)...)
+
+	if useAIA || *grandparentKey != "" {
+		err = checkValidityWithin(&template, &aiaParent)
+		if err != nil {
+			log.Fatalf("Invalid -ca-duration: %v", err)
+		}
+	}
+
 	//derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
 	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &aiaParent, publicKey(priv), aiaParentPriv)
 	if err != nil {
 		log.Fatalf("Failed to create certificate: %v", err)
 	}
 
-	//certOut, err := os.Create("cert.pem")
-	certOut, err := os.Create("caCert.pem")
-	if err != nil {
-		//log.Fatalf("failed to open cert.pem for writing: %v", err)
-		log.Fatalf("Failed to open caCert.pem for writing: %v", err)
-	}
-	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
-		//log.Fatalf("Failed to write data to cert.pem: %v", err)
-		log.Fatalf("Failed to write data to caCert.pem: %v", err)
-	}
-	if err := certOut.Close(); err != nil {
-		//log.Fatalf("Error closing cert.pem: %v", err)
-		log.Fatalf("Error closing caCert.pem: %v", err)
-	}
-	//log.Print("wrote cert.pem\n")
-	log.Print("wrote caCert.pem\n")
+	stageArtifact("caCert.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), 0644)
 
 	if *parentKey != "" {
 		return template, priv
 	}
 
-	//keyOut, err := os.OpenFile("key.pem", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	keyOut, err := os.OpenFile("caKey.pem", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		//log.Fatalf("Failed to open key.pem for writing: %v", err)
-		log.Fatalf("Failed to open caKey.pem for writing: %v", err)
-		//return
-	}
-	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	privPEM, err := marshalPrivateKeyPEM(priv, encryptCAKeys())
 	if err != nil {
 		log.Fatalf("Unable to marshal private key: %v", err)
 	}
-	if err := pem.Encode(keyOut, &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}); err != nil {
-		//log.Fatalf("Failed to write data to key.pem: %v", err)
-		log.Fatalf("Failed to write data to caKey.pem: %v", err)
-	}
-	if err := keyOut.Close(); err != nil {
-		//log.Fatalf("Error closing key.pem: %v", err)
-		log.Fatalf("Error closing caKey.pem: %v", err)
-	}
-	//log.Print("wrote key.pem\n")
-	log.Print("wrote caKey.pem\n")
+	stageArtifact("caKey.pem", privPEM, 0600)
 
 	return template, priv
 }
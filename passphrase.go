@@ -0,0 +1,159 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// passphraseSource is where one passphrase comes from: an environment
+// variable, a file descriptor, or a TTY prompt.  Each source is only read
+// once per run.
+type passphraseSource struct {
+	env         *string
+	fd          *int
+	description string
+	confirm     bool
+
+	cached []byte
+}
+
+// Generated keys are encrypted with a different passphrase from the one(s)
+// that decrypt -parent-key and -grandparent-key, so that a new key is never
+// silently encrypted with a passphrase typed in to unlock an old one.
+var (
+	encryptPassphrase        = &passphraseSource{env: passphraseEnv, fd: passphraseFD, description: "new private key", confirm: true}
+	parentKeyPassphrase      = &passphraseSource{env: parentKeyPassphraseEnv, fd: parentKeyPassphraseFD, description: "-parent-key"}
+	grandparentKeyPassphrase = &passphraseSource{env: grandparentKeyPassphraseEnv, fd: grandparentKeyPassphraseFD, description: "-grandparent-key"}
+)
+
+// get returns the passphrase, taken from the environment variable named by
+// s.env, the file descriptor given by s.fd, or a TTY prompt, in that order of
+// preference.  If s.confirm is set and the user is prompted, they must enter
+// it twice.
+func (s *passphraseSource) get() ([]byte, error) {
+	if s.cached != nil {
+		return s.cached, nil
+	}
+
+	passphrase, err := readPassphrase(*s.env, *s.fd, s.description, s.confirm)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cached = passphrase
+
+	return passphrase, nil
+}
+
+// fdPassphrases caches the line read from each passphrase file descriptor,
+// so that the same fd can be given to several passphrase flags.  fdFiles
+// keeps the fds' *os.Files reachable, since a collected *os.File closes its
+// fd.
+var (
+	fdPassphrases = map[int][]byte{}
+	fdFiles       = map[int]*os.File{}
+)
+
+// readPassphraseFD reads the first line from fd.  It reads a byte at a time,
+// so that nothing after the line is consumed.
+func readPassphraseFD(fd int) ([]byte, error) {
+	if passphrase, ok := fdPassphrases[fd]; ok {
+		return passphrase, nil
+	}
+
+	file, ok := fdFiles[fd]
+	if !ok {
+		file = os.NewFile(uintptr(fd), "passphrase")
+		if file == nil {
+			return nil, fmt.Errorf("invalid file descriptor %d", fd)
+		}
+		fdFiles[fd] = file
+	}
+
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := file.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+			continue
+		}
+		if err == io.EOF && len(line) != 0 {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase from file descriptor %d: %w", fd, err)
+		}
+	}
+
+	passphrase := bytes.TrimRight(line, "\r")
+	fdPassphrases[fd] = passphrase
+
+	return passphrase, nil
+}
+
+// readPassphrase reads a passphrase from the environment variable envName
+// (if non-empty), else from the file descriptor fd (if non-negative), else
+// by prompting on the terminal.  description is used in the prompt.
+func readPassphrase(envName string, fd int, description string, confirm bool) ([]byte, error) {
+	var passphrase []byte
+
+	switch {
+	case envName != "":
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", envName)
+		}
+		passphrase = []byte(value)
+	case fd >= 0:
+		var err error
+		passphrase, err = readPassphraseFD(fd)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		stdinFD := int(os.Stdin.Fd())
+		if !term.IsTerminal(stdinFD) {
+			return nil, fmt.Errorf("no %s passphrase source; stdin is not a terminal", description)
+		}
+
+		fmt.Fprintf(os.Stderr, "Enter %s passphrase: ", description)
+		value, err := term.ReadPassword(stdinFD)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+
+		if confirm {
+			fmt.Fprintf(os.Stderr, "Confirm %s passphrase: ", description)
+			confirmValue, err := term.ReadPassword(stdinFD)
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return nil, err
+			}
+
+			if !bytes.Equal(value, confirmValue) {
+				return nil, fmt.Errorf("%s passphrases do not match", description)
+			}
+		}
+
+		passphrase = value
+	}
+
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%s passphrase is empty", description)
+	}
+
+	return passphrase, nil
+}
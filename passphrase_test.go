@@ -0,0 +1,71 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPassphraseSharedFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	_, err = w.WriteString("secret\r\nleftover\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	noEnv := ""
+	// Share r, so that a second *os.File doesn't close the fd too.
+	fd := int(r.Fd())
+	fdFiles[fd] = r
+	t.Cleanup(func() {
+		delete(fdPassphrases, fd)
+		delete(fdFiles, fd)
+	})
+
+	// As with -passphrase-fd and -parent-key-passphrase-fd given the same
+	// fd.
+	encrypt := &passphraseSource{env: &noEnv, fd: &fd, description: "new private key", confirm: true}
+	decrypt := &passphraseSource{env: &noEnv, fd: &fd, description: "-parent-key"}
+
+	for _, source := range []*passphraseSource{decrypt, encrypt} {
+		passphrase, err := source.get()
+		if err != nil {
+			t.Fatalf("%s passphrase: %v", source.description, err)
+		}
+
+		if string(passphrase) != "secret" {
+			t.Errorf("%s passphrase = %q, want %q", source.description, passphrase, "secret")
+		}
+	}
+}
+
+func TestPassphraseFDEmpty(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w.Close()
+
+	// Share r, so that a second *os.File doesn't close the fd too.
+	fd := int(r.Fd())
+	fdFiles[fd] = r
+	t.Cleanup(func() {
+		delete(fdPassphrases, fd)
+		delete(fdFiles, fd)
+	})
+
+	_, err = readPassphrase("", fd, "test", false)
+	if err == nil {
+		t.Error("reading a passphrase from an empty fd succeeded; expected an error")
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !pkcs11
+// +build !pkcs11
+
+package main
+
+import (
+	"crypto"
+	"fmt"
+)
+
+func loadPKCS11Signer(uri string, generate bool) (crypto.Signer, error) {
+	return nil, fmt.Errorf("ncgencert was built without PKCS#11 support; rebuild with \"-tags pkcs11\" to use %s", uri)
+}
@@ -0,0 +1,253 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build pkcs11
+// +build pkcs11
+
+package main
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// pkcs11URI holds the attributes of an RFC 7512 PKCS#11 URI that ncgencert
+// understands.
+type pkcs11URI struct {
+	token      string
+	serial     string
+	slotID     *int
+	object     []byte
+	id         []byte
+	modulePath string
+	pinValue   string
+	pinSource  string
+}
+
+// parsePKCS11URI parses an RFC 7512 URI such as
+// "pkcs11:token=ncgencert;object=example.bit%20Domain%20CA?module-path=/usr/lib/softhsm/libsofthsm2.so".
+func parsePKCS11URI(uri string) (*pkcs11URI, error) {
+	if !strings.HasPrefix(uri, "pkcs11:") {
+		return nil, fmt.Errorf("not a PKCS#11 URI: %q", uri)
+	}
+
+	result := &pkcs11URI{}
+
+	pathPart := strings.TrimPrefix(uri, "pkcs11:")
+	queryPart := ""
+	if i := strings.IndexByte(pathPart, '?'); i >= 0 {
+		pathPart, queryPart = pathPart[:i], pathPart[i+1:]
+	}
+
+	parseAttrs := func(part, sep string, handle func(name, value string) error) error {
+		if part == "" {
+			return nil
+		}
+
+		for _, attr := range strings.Split(part, sep) {
+			name, rawValue, ok := strings.Cut(attr, "=")
+			if !ok {
+				return fmt.Errorf("malformed PKCS#11 URI attribute %q", attr)
+			}
+
+			value, err := url.PathUnescape(rawValue)
+			if err != nil {
+				return fmt.Errorf("malformed PKCS#11 URI attribute %q: %w", attr, err)
+			}
+
+			err = handle(name, value)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	err := parseAttrs(pathPart, ";", func(name, value string) error {
+		switch name {
+		case "token":
+			result.token = value
+		case "serial":
+			result.serial = value
+		case "slot-id":
+			slotID, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("malformed PKCS#11 slot-id %q: %w", value, err)
+			}
+			result.slotID = &slotID
+		case "object":
+			result.object = []byte(value)
+		case "id":
+			result.id = []byte(value)
+		case "type":
+			if value != "private" {
+				return fmt.Errorf("PKCS#11 URI must refer to a private key, not %q", value)
+			}
+		default:
+			// RFC 7512 allows other attributes (e.g. manufacturer); they
+			// don't affect how we locate the key.
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = parseAttrs(queryPart, "&", func(name, value string) error {
+		switch name {
+		case "module-path":
+			result.modulePath = value
+		case "pin-value":
+			result.pinValue = value
+		case "pin-source":
+			result.pinSource = value
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.token == "" && result.serial == "" && result.slotID == nil {
+		return nil, fmt.Errorf("PKCS#11 URI must specify a token, serial or slot-id")
+	}
+
+	if result.object == nil && result.id == nil {
+		return nil, fmt.Errorf("PKCS#11 URI must specify an object or id")
+	}
+
+	return result, nil
+}
+
+// pin returns the user PIN, from the URI if present, otherwise by prompting.
+func (u *pkcs11URI) pin() (string, error) {
+	if u.pinValue != "" {
+		return u.pinValue, nil
+	}
+
+	if u.pinSource != "" {
+		pinPath := strings.TrimPrefix(u.pinSource, "file:")
+		pinBytes, err := ioutil.ReadFile(pinPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read PKCS#11 PIN: %w", err)
+		}
+
+		return strings.TrimRight(string(pinBytes), "\r\n"), nil
+	}
+
+	pinBytes, err := readPassphrase("", -1, "PKCS#11 PIN", false)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pinBytes), nil
+}
+
+// loadPKCS11Signer returns a signer for the key referred to by a PKCS#11
+// URI.  If generate is set, a new key pair is generated on the token instead
+// of looking up an existing one.
+func loadPKCS11Signer(uri string, generate bool) (crypto.Signer, error) {
+	parsed, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	modulePath := parsed.modulePath
+	if modulePath == "" {
+		modulePath = *pkcs11Module
+	}
+	if modulePath == "" {
+		return nil, fmt.Errorf("PKCS#11 URI has no module-path and -pkcs11-module is not set")
+	}
+
+	pin, err := parsed.pin()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := crypto11.Configure(&crypto11.Config{
+		Path:        modulePath,
+		TokenLabel:  parsed.token,
+		TokenSerial: parsed.serial,
+		SlotNumber:  parsed.slotID,
+		Pin:         pin,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PKCS#11 token: %w", err)
+	}
+
+	existing, err := ctx.FindKeyPair(parsed.id, parsed.object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search PKCS#11 token: %w", err)
+	}
+
+	if !generate {
+		if existing == nil {
+			return nil, fmt.Errorf("no key pair matching %s found on PKCS#11 token", uri)
+		}
+
+		return existing, nil
+	}
+
+	if existing != nil {
+		return nil, fmt.Errorf("a key pair matching %s already exists on PKCS#11 token", uri)
+	}
+
+	if *ed25519Key {
+		return nil, fmt.Errorf("PKCS#11 key generation only supports ECDSA keys")
+	}
+
+	var curve elliptic.Curve
+	switch *ecdsaCurve {
+	case "P224":
+		curve = elliptic.P224()
+	case "P256":
+		curve = elliptic.P256()
+	case "P384":
+		curve = elliptic.P384()
+	case "P521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("PKCS#11 key generation only supports ECDSA curves, not %q", *ecdsaCurve)
+	}
+
+	id := parsed.id
+	if id == nil {
+		id = make([]byte, 16)
+		_, err = rand.Read(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var signer crypto11.Signer
+	if parsed.object != nil {
+		signer, err = ctx.GenerateECDSAKeyPairWithLabel(id, parsed.object, curve)
+	} else {
+		signer, err = ctx.GenerateECDSAKeyPair(id, curve)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key pair on PKCS#11 token: %w", err)
+	}
+
+	var idStr strings.Builder
+	for _, b := range id {
+		fmt.Fprintf(&idStr, "%%%02x", b)
+	}
+	log.Printf("Generated key pair on PKCS#11 token with id=%s", idStr.String())
+
+	return signer, nil
+}
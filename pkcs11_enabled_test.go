@@ -0,0 +1,171 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build pkcs11
+// +build pkcs11
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestParsePKCS11URI(t *testing.T) {
+	uri, err := parsePKCS11URI("pkcs11:token=ncgencert;object=example.bit%20Domain%20CA;type=private;manufacturer=foo?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.token != "ncgencert" {
+		t.Errorf("token = %q", uri.token)
+	}
+	if !bytes.Equal(uri.object, []byte("example.bit Domain CA")) {
+		t.Errorf("object = %q", uri.object)
+	}
+	if uri.modulePath != "/usr/lib/softhsm/libsofthsm2.so" {
+		t.Errorf("module-path = %q", uri.modulePath)
+	}
+	if uri.pinValue != "1234" {
+		t.Errorf("pin-value = %q", uri.pinValue)
+	}
+
+	uri, err = parsePKCS11URI("pkcs11:slot-id=3;id=%01%02?pin-source=file:/etc/pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.slotID == nil || *uri.slotID != 3 {
+		t.Errorf("slot-id = %v", uri.slotID)
+	}
+	if !bytes.Equal(uri.id, []byte{1, 2}) {
+		t.Errorf("id = %x", uri.id)
+	}
+	if uri.pinSource != "file:/etc/pin" {
+		t.Errorf("pin-source = %q", uri.pinSource)
+	}
+}
+
+func TestParsePKCS11URIInvalid(t *testing.T) {
+	for _, uri := range []string{
+		"caKey.pem",
+		"pkcs11:",
+		"pkcs11:object=foo",
+		"pkcs11:token=foo",
+		"pkcs11:token=foo;object=bar;type=public",
+		"pkcs11:token=foo;object",
+		"pkcs11:token=foo;object=%zz",
+		"pkcs11:slot-id=x;object=bar",
+	} {
+		_, err := parsePKCS11URI(uri)
+		if err == nil {
+			t.Errorf("parsePKCS11URI(%q) succeeded; expected an error", uri)
+		}
+	}
+}
+
+// softHSMModulePaths are where distributions install SoftHSM's PKCS#11
+// module, if SOFTHSM2_MODULE doesn't say.
+var softHSMModulePaths = []string{
+	"/usr/lib/softhsm/libsofthsm2.so",
+	"/usr/lib64/softhsm/libsofthsm2.so",
+	"/usr/lib/x86_64-linux-gnu/softhsm/libsofthsm2.so",
+	"/usr/local/lib/softhsm/libsofthsm2.so",
+}
+
+// TestPKCS11SoftHSM generates a key pair on a fresh SoftHSM token and signs a
+// cert with it.  It needs SOFTHSM2_CONF to point at a SoftHSM config with a
+// writable token directory, softhsm2-util, and the SoftHSM module (found via
+// SOFTHSM2_MODULE or softHSMModulePaths).
+func TestPKCS11SoftHSM(t *testing.T) {
+	if os.Getenv("SOFTHSM2_CONF") == "" {
+		t.Skip("SOFTHSM2_CONF is not set")
+	}
+
+	modulePath := os.Getenv("SOFTHSM2_MODULE")
+	if modulePath == "" {
+		for _, path := range softHSMModulePaths {
+			if _, err := os.Stat(path); err == nil {
+				modulePath = path
+				break
+			}
+		}
+	}
+	if modulePath == "" {
+		t.Skip("SoftHSM module not found; set SOFTHSM2_MODULE")
+	}
+
+	if _, err := exec.LookPath("softhsm2-util"); err != nil {
+		t.Skip("softhsm2-util not found")
+	}
+
+	// Token labels are limited to 32 bytes.
+	label := fmt.Sprintf("ncgencert-test-%d", time.Now().Unix())
+	out, err := exec.Command("softhsm2-util", "--init-token", "--free", "--label", label, "--pin", "1234", "--so-pin", "5678").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Failed to initialize SoftHSM token: %v\n%s", err, out)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("softhsm2-util", "--delete-token", "--token", label).Run()
+	})
+
+	oldCurve := *ecdsaCurve
+	*ecdsaCurve = "P256"
+	t.Cleanup(func() { *ecdsaCurve = oldCurve })
+
+	uri := "pkcs11:token=" + label + ";object=test%20key?module-path=" + modulePath + "&pin-value=1234"
+
+	generated, err := loadPKCS11Signer(uri, true)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	_, err = loadPKCS11Signer(uri, true)
+	if err == nil {
+		t.Error("Generating a second key pair with the same label succeeded; expected an error")
+	}
+
+	signer, err := loadPKCS11Signer(uri, false)
+	if err != nil {
+		t.Fatalf("Failed to load generated key pair: %v", err)
+	}
+
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(generated.Public()) {
+		t.Fatalf("Loaded public key %v doesn't match generated %v", signer.Public(), generated.Public())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SoftHSM test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatalf("Failed to sign cert with PKCS#11 key: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cert.CheckSignatureFrom(cert)
+	if err != nil {
+		t.Fatalf("PKCS#11 key made an invalid signature: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// writePKCS12 bundles the end-entity private key with the cert chain written
+// by writeChain into cert.p12, for servers that can't consume PEM.
+func writePKCS12(priv any) {
+	certs, err := stagedCertChain("chain.pem")
+	if err != nil {
+		log.Fatalf("Failed to parse chain.pem: %v", err)
+	}
+
+	// Don't prompt for a passphrase just to list cert.p12 in a dry run.
+	if *dryRun {
+		stageArtifact("cert.p12", nil, 0600)
+		return
+	}
+
+	password, err := readPassphrase(*pkcs12PassphraseEnv, *pkcs12PassphraseFD, "PKCS#12", true)
+	if err != nil {
+		log.Fatalf("Failed to get PKCS#12 passphrase: %v", err)
+	}
+
+	// Modern uses PBES2 with PBKDF2 and AES-256-CBC, and a SHA-256 MAC.
+	pfxBytes, err := pkcs12.Modern.Encode(priv, certs[0], certs[1:], string(password))
+	if err != nil {
+		log.Fatalf("Failed to encode PKCS#12 bundle: %v", err)
+	}
+
+	stageArtifact("cert.p12", pfxBytes, 0600)
+}
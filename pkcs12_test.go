@@ -0,0 +1,131 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// stageTestChain stages a chain.pem holding an end-entity cert and its CA, as
+// writeChain would, and returns the end-entity key and the chain.  Staged
+// artifacts are restored when the test finishes.
+func stageTestChain(t *testing.T) (*ecdsa.PrivateKey, []*x509.Certificate) {
+	t.Helper()
+
+	oldArtifacts := stagedArtifacts
+	stagedArtifacts = nil
+	t.Cleanup(func() { stagedArtifacts = oldArtifacts })
+
+	caPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.bit Domain CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	caBytes, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caPriv.PublicKey, caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.bit"},
+		DNSNames:     []string{"example.bit"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	leafBytes, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &priv.PublicKey, caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(leafBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs := []*x509.Certificate{leaf, ca}
+	stageArtifact("chain.pem", encodeCertChain(certs), 0644)
+
+	return priv, certs
+}
+
+func TestWritePKCS12(t *testing.T) {
+	priv, certs := stageTestChain(t)
+
+	t.Setenv("NCGENCERT_TEST_PKCS12_PASSPHRASE", "hunter2")
+	oldEnv := *pkcs12PassphraseEnv
+	*pkcs12PassphraseEnv = "NCGENCERT_TEST_PKCS12_PASSPHRASE"
+	t.Cleanup(func() { *pkcs12PassphraseEnv = oldEnv })
+
+	writePKCS12(priv)
+
+	pfxBytes, ok := stagedArtifact("cert.p12")
+	if !ok {
+		t.Fatal("cert.p12 was not staged")
+	}
+
+	decodedKey, leaf, caCerts, err := pkcs12.DecodeChain(pfxBytes, "hunter2")
+	if err != nil {
+		t.Fatalf("Failed to decode cert.p12: %v", err)
+	}
+
+	if !priv.Equal(decodedKey) {
+		t.Error("cert.p12 holds the wrong private key")
+	}
+
+	if !bytes.Equal(leaf.Raw, certs[0].Raw) {
+		t.Error("cert.p12 holds the wrong end-entity cert")
+	}
+
+	chainCerts, err := stagedCertChain("chain.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(caCerts) != len(chainCerts)-1 {
+		t.Fatalf("cert.p12 holds %d CA certs; chain.pem holds %d", len(caCerts), len(chainCerts)-1)
+	}
+	for i, caCert := range caCerts {
+		if !bytes.Equal(caCert.Raw, chainCerts[i+1].Raw) {
+			t.Errorf("cert.p12 CA cert %d doesn't match chain.pem", i)
+		}
+	}
+
+	_, _, _, err = pkcs12.DecodeChain(pfxBytes, "wrong")
+	if err == nil {
+		t.Error("Decoding cert.p12 with the wrong passphrase succeeded")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// Values of -sigs-encoding.
+const (
+	sigsEncodingRaw     = "raw"
+	sigsEncodingCompact = "compact"
+)
+
+// compactSigLength is the length of a Namecoin message signature (a
+// recoverable secp256k1 signature, as produced by signmessage).
+const compactSigLength = 65
+
+// strictestURLLimit is the shortest of knownURLLimits.
+const strictestURLLimit = 2083
+
+// knownURLLimits are the URL lengths beyond which some clients are known to
+// truncate or refuse URLs.
+var knownURLLimits = []struct {
+	limit  int
+	client string
+}{
+	{strictestURLLimit, "Internet Explorer and older Windows URL handling"},
+	{8000, "the minimum that RFC 9110 recommends senders and recipients support"},
+}
+
+// parseSigs parses a -sigs file: base64 Namecoin message signatures, either
+// separated by whitespace or as a JSON array of strings.
+func parseSigs(sigsBytes []byte) ([][]byte, error) {
+	var sigStrs []string
+	err := json.Unmarshal(sigsBytes, &sigStrs)
+	if err != nil {
+		sigStrs = strings.Fields(string(sigsBytes))
+	}
+
+	if len(sigStrs) == 0 {
+		return nil, fmt.Errorf("no signatures found")
+	}
+
+	result := make([][]byte, 0, len(sigStrs))
+	for _, sigStr := range sigStrs {
+		sig, err := base64.StdEncoding.DecodeString(sigStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature %q: %w", sigStr, err)
+		}
+
+		if len(sig) != compactSigLength {
+			return nil, fmt.Errorf("signature %q is %d bytes; expected %d", sigStr, len(sig), compactSigLength)
+		}
+
+		result = append(result, sig)
+	}
+
+	return result, nil
+}
+
+// encodeSigsCompact deduplicates sigs and concatenates them (they're all
+// compactSigLength bytes, so no framing is needed), as base64url without
+// padding.
+func encodeSigsCompact(sigs [][]byte) string {
+	var concatenated []byte
+	seen := map[string]bool{}
+
+	for _, sig := range sigs {
+		if seen[string(sig)] {
+			continue
+		}
+		seen[string(sig)] = true
+
+		concatenated = append(concatenated, sig...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(concatenated)
+}
+
+// stapledSigs returns the stapled data key and value that carry the -sigs
+// file's contents, in the -sigs-encoding format: "sigs" with the file
+// contents verbatim, or "sigsb64" with encodeSigsCompact.  The same key and
+// value go in both the AIA parent's stapled data and the AIA URL, so that
+// Encaya rebuilds an identical AIA parent.
+func stapledSigs(sigsBytes []byte) (string, string) {
+	if *sigsEncoding == sigsEncodingRaw {
+		return stapledKeySigs, string(sigsBytes)
+	}
+
+	parsedSigs, err := parseSigs(bytes.TrimSpace(sigsBytes))
+	if err != nil {
+		log.Fatalf("Failed to parse stapled sigs: %v", err)
+	}
+
+	return stapledKeySigsB64, encodeSigsCompact(parsedSigs)
+}
+
+// aiaSigsParam returns the AIA URL query parameter that carries the -sigs
+// file's contents, as chosen by stapledSigs.
+func aiaSigsParam(sigsBytes []byte) string {
+	rawParam := "&" + stapledKeySigs + "=" + url.QueryEscape(string(sigsBytes))
+
+	key, value := stapledSigs(sigsBytes)
+	param := "&" + key + "=" + url.QueryEscape(value)
+
+	if *sigsEncoding == sigsEncodingRaw {
+		log.Printf("Stapled sigs take %d bytes of the AIA URL; -sigs-encoding compact may shorten this", len(param))
+	} else {
+		log.Printf("Stapled sigs take %d bytes of the AIA URL (%d bytes with -sigs-encoding raw)", len(param), len(rawParam))
+	}
+
+	return param
+}
+
+// checkAIAURLLength warns about AIA URLs that some clients won't accept.
+func checkAIAURLLength(aiaURL string) {
+	log.Printf("AIA URL is %d bytes", len(aiaURL))
+
+	for _, known := range knownURLLimits {
+		if len(aiaURL) > known.limit {
+			log.Printf("WARNING: AIA URL is longer than %d bytes, the limit of %s", known.limit, known.client)
+		}
+	}
+}
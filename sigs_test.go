@@ -0,0 +1,72 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// testSig returns a dummy compactSigLength-byte signature filled with b.
+func testSig(b byte) []byte {
+	return bytes.Repeat([]byte{b}, compactSigLength)
+}
+
+func TestParseSigs(t *testing.T) {
+	sig1 := base64.StdEncoding.EncodeToString(testSig(1))
+	sig2 := base64.StdEncoding.EncodeToString(testSig(2))
+
+	for _, input := range []string{
+		sig1 + "\n" + sig2 + "\n",
+		sig1 + " " + sig2,
+		`["` + sig1 + `", "` + sig2 + `"]`,
+	} {
+		sigs, err := parseSigs([]byte(input))
+		if err != nil {
+			t.Errorf("parseSigs(%q): %v", input, err)
+			continue
+		}
+
+		if len(sigs) != 2 || !bytes.Equal(sigs[0], testSig(1)) || !bytes.Equal(sigs[1], testSig(2)) {
+			t.Errorf("parseSigs(%q) = %x", input, sigs)
+		}
+	}
+}
+
+func TestParseSigsInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"  \n",
+		"[]",
+		"not-base64!",
+		base64.StdEncoding.EncodeToString(testSig(1)[1:]),
+		base64.StdEncoding.EncodeToString(append(testSig(1), 0)),
+	} {
+		_, err := parseSigs([]byte(input))
+		if err == nil {
+			t.Errorf("parseSigs(%q) succeeded; expected an error", input)
+		}
+	}
+}
+
+func TestEncodeSigsCompact(t *testing.T) {
+	encoded := encodeSigsCompact([][]byte{testSig(1), testSig(2), testSig(1)})
+
+	if strings.ContainsAny(encoded, "+/=") {
+		t.Errorf("encodeSigsCompact returned %q; expected unpadded base64url", encoded)
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append(testSig(1), testSig(2)...)
+	if !bytes.Equal(decoded, want) {
+		t.Errorf("encodeSigsCompact decoded to %x, want %x", decoded, want)
+	}
+}
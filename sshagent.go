@@ -0,0 +1,111 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type sshAgentSigner struct {
+	agent agent.ExtendedAgent
+	key   ssh.PublicKey
+	pub   ed25519.PublicKey
+}
+
+// loadSSHAgentSigner returns a signer for an Ed25519 key held by the
+// ssh-agent at $SSH_AUTH_SOCK.  path is of the form "ssh-agent:SELECTOR",
+// where SELECTOR is the key's comment or its SHA256 fingerprint (as printed
+// by "ssh-add -l").  An empty SELECTOR picks the agent's only Ed25519 key.
+func loadSSHAgentSigner(path string) (crypto.Signer, error) {
+	selector := strings.TrimPrefix(path, "ssh-agent:")
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+	}
+
+	client := agent.NewClient(conn)
+
+	keys, err := client.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+
+	var matches []*sshAgentSigner
+	for _, key := range keys {
+		if key.Format != ssh.KeyAlgoED25519 {
+			continue
+		}
+
+		pub, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			return nil, fmt.Errorf("ssh-agent returned an invalid key: %w", err)
+		}
+
+		if selector != "" && selector != key.Comment && selector != ssh.FingerprintSHA256(pub) {
+			continue
+		}
+
+		cryptoPub, ok := pub.(ssh.CryptoPublicKey)
+		if !ok {
+			continue
+		}
+
+		edPub, ok := cryptoPub.CryptoPublicKey().(ed25519.PublicKey)
+		if !ok {
+			continue
+		}
+
+		matches = append(matches, &sshAgentSigner{
+			agent: client,
+			key:   pub,
+			pub:   edPub,
+		})
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no Ed25519 key matching %q found in ssh-agent", selector)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("%d Ed25519 keys in ssh-agent match %q; select one by comment or SHA256 fingerprint", len(matches), selector)
+	}
+}
+
+func (s *sshAgentSigner) Public() crypto.PublicKey {
+	return s.pub
+}
+
+func (s *sshAgentSigner) Sign(_ io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != 0 {
+		return nil, fmt.Errorf("ssh-agent Ed25519 keys can't sign prehashed messages")
+	}
+
+	sig, err := s.agent.Sign(s.key, message)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-agent failed to sign: %w", err)
+	}
+
+	if sig.Format != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("ssh-agent returned an unexpected %q signature", sig.Format)
+	}
+
+	return sig.Blob, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveTestAgent serves keyring on a Unix socket, and points SSH_AUTH_SOCK
+// at it for the rest of the test.
+func serveTestAgent(t *testing.T, keyring agent.Agent) {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+}
+
+func TestSSHAgentSigner(t *testing.T) {
+	keyring := agent.NewKeyring()
+
+	edKeys := map[string]ed25519.PrivateKey{}
+	for _, comment := range []string{"alpha", "beta"} {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: comment})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		edKeys[comment] = priv
+	}
+
+	// Only Ed25519 keys are usable, so this one is never selected.
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = keyring.Add(agent.AddedKey{PrivateKey: ecKey, Comment: "gamma"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveTestAgent(t, keyring)
+
+	betaSSHPub, err := ssh.NewPublicKey(edKeys["beta"].Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selections := []struct {
+		selector string
+		want     ed25519.PrivateKey
+	}{
+		{"alpha", edKeys["alpha"]},
+		{ssh.FingerprintSHA256(betaSSHPub), edKeys["beta"]},
+	}
+
+	for _, selection := range selections {
+		signer, err := loadSSHAgentSigner("ssh-agent:" + selection.selector)
+		if err != nil {
+			t.Errorf("loadSSHAgentSigner(%q): %v", selection.selector, err)
+			continue
+		}
+
+		if !selection.want.Public().(ed25519.PublicKey).Equal(signer.Public()) {
+			t.Errorf("loadSSHAgentSigner(%q) selected the wrong key", selection.selector)
+			continue
+		}
+
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "ssh-agent test"},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+
+		certBytes, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+		if err != nil {
+			t.Errorf("Failed to sign cert with ssh-agent key %q: %v", selection.selector, err)
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(certBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = cert.CheckSignatureFrom(cert)
+		if err != nil {
+			t.Errorf("ssh-agent key %q made an invalid signature: %v", selection.selector, err)
+		}
+	}
+
+	for _, selector := range []string{"", "gamma", "delta"} {
+		_, err := loadSSHAgentSigner("ssh-agent:" + selector)
+		if err == nil {
+			t.Errorf("loadSSHAgentSigner(%q) succeeded; expected an error", selector)
+		}
+	}
+}
+
+func TestSSHAgentSignerNoAgent(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, err := loadSSHAgentSigner("ssh-agent:")
+	if err == nil {
+		t.Error("loadSSHAgentSigner without SSH_AUTH_SOCK succeeded; expected an error")
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Stapled data is carried in the Subject Serial Number of the AIA parent CA
+// (and therefore in the Issuer of the domain CA), after the usual
+// "Namecoin TLS Certificate" value:
+//
+//	Namecoin TLS Certificate
+//
+//	Stapled: {"pubb64":"...","sigs":"...","v":"1"}
+//
+// Encaya rebuilds the AIA parent from its AIA URL's query parameters, so its
+// Subject, and hence the domain CA's Issuer, only match if every stapled
+// field is also in the AIA URL.  The JSON object's values are all strings, as
+// verifiers decode it as map[string]string.  Version 1 defines:
+//
+//	v         Schema version, always written (and put in the AIA URL as
+//	          v=1).  Parsers treat a missing v as version 1, for certs
+//	          generated before it was written.
+//	pubb64    Required.  The AIA parent's public key, as base64url (no
+//	          padding) PKIX DER.
+//	sigs      Optional.  The Namecoin message signatures stapled with -sigs,
+//	          verbatim.
+//	sigsb64   Optional; instead of sigs, with -sigs-encoding compact.  The
+//	          deduplicated 65-byte signatures, concatenated, as base64url (no
+//	          padding).
+//	pidigits  Optional.  Digits of pi, for the pi.x--nmc.bit meta-domain.
+//
+// Parsers must ignore fields they don't recognize, so new optional fields
+// can be added without a version bump.  The version only changes when
+// existing fields change meaning, and parsers must reject versions they
+// don't know.
+//
+// Since packing JSON into a DN attribute upsets some DN parsers and length
+// limits, the same JSON can also, with -stapled-encoding both, be carried as
+// a UTF8String in a non-critical extension, oidStapledData.  Carrying it only
+// in the extension (-stapled-encoding extension) is rejected until Encaya
+// can rebuild an AIA parent to match.
+// The AIA parent is never written, so the extension is copied to the domain
+// CA; there, it describes the domain CA's issuer, exactly like the stapled
+// data in the domain CA's Issuer Serial Number.
+
+const (
+	stapledPrefix  = "\n\nStapled: "
+	stapledVersion = "1"
+
+	stapledKeyVersion  = "v"
+	stapledKeyPubB64   = "pubb64"
+	stapledKeySigs     = "sigs"
+	stapledKeySigsB64  = "sigsb64"
+	stapledKeyPiDigits = "pidigits"
+)
+
+// oidStapledData identifies the stapled data extension.  It is a placeholder
+// under the arc that RFC 5612 reserves for documentation, so the extension
+// is experimental, and must not be relied on, until Namecoin has a Private
+// Enterprise Number of its own to move it to.
+var oidStapledData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 1, 1}
+
+// Values of -stapled-encoding.
+const (
+	stapledEncodingSerial    = "serial"
+	stapledEncodingExtension = "extension"
+	stapledEncodingBoth      = "both"
+)
+
+func stapledInSerial() bool {
+	return *stapledEncoding == stapledEncodingSerial || *stapledEncoding == stapledEncodingBoth
+}
+
+func stapledInExtension() bool {
+	return *stapledEncoding == stapledEncodingExtension || *stapledEncoding == stapledEncodingBoth
+}
+
+// errNoStapledData is returned by parseStapledData and
+// parseStapledExtension when there's no stapled data to parse.
+var errNoStapledData = errors.New("no stapled data")
+
+// encodeStapledData returns the suffix to append to the AIA parent's Subject
+// Serial Number to carry stapled.
+func encodeStapledData(stapled map[string]string) (string, error) {
+	stapledBytes, err := json.Marshal(stapled)
+	if err != nil {
+		return "", err
+	}
+
+	return stapledPrefix + string(stapledBytes), nil
+}
+
+// encodeStapledExtension returns the stapled data extension carrying
+// stapled.
+func encodeStapledExtension(stapled map[string]string) (pkix.Extension, error) {
+	stapledBytes, err := json.Marshal(stapled)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	value, err := asn1.MarshalWithParams(string(stapledBytes), "utf8")
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidStapledData, Critical: false, Value: value}, nil
+}
+
+// stapledExtensions returns the stapled data extensions that template will
+// be issued with.
+func stapledExtensions(template *x509.Certificate) []pkix.Extension {
+	var result []pkix.Extension
+	for _, ext := range template.ExtraExtensions {
+		if ext.Id.Equal(oidStapledData) {
+			result = append(result, ext)
+		}
+	}
+
+	return result
+}
+
+// parseStapledData extracts and validates the stapled data from a Subject
+// (or Issuer) Serial Number.  The returned map includes any fields this
+// version of ncgencert doesn't know about.
+func parseStapledData(serialNumber string) (map[string]string, error) {
+	_, stapledJSON, found := strings.Cut(serialNumber, stapledPrefix)
+	if !found {
+		return nil, errNoStapledData
+	}
+
+	return parseStapledJSON([]byte(stapledJSON))
+}
+
+// parseStapledExtension extracts and validates the stapled data from cert's
+// stapled data extension.
+func parseStapledExtension(cert *x509.Certificate) (map[string]string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidStapledData) {
+			continue
+		}
+
+		var stapledJSON string
+		rest, err := asn1.UnmarshalWithParams(ext.Value, &stapledJSON, "utf8")
+		if err != nil {
+			return nil, fmt.Errorf("malformed stapled data extension: %w", err)
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("trailing data after stapled data extension")
+		}
+
+		return parseStapledJSON([]byte(stapledJSON))
+	}
+
+	return nil, errNoStapledData
+}
+
+func parseStapledJSON(stapledJSON []byte) (map[string]string, error) {
+	stapled := map[string]string{}
+	err := json.Unmarshal(stapledJSON, &stapled)
+	if err != nil {
+		return nil, fmt.Errorf("malformed stapled data: %w", err)
+	}
+
+	version, ok := stapled[stapledKeyVersion]
+	if !ok {
+		stapled[stapledKeyVersion] = stapledVersion
+	} else if version != stapledVersion {
+		return nil, fmt.Errorf("unsupported stapled data version %q", version)
+	}
+
+	pubB64, ok := stapled[stapledKeyPubB64]
+	if !ok {
+		return nil, fmt.Errorf("stapled data has no %s", stapledKeyPubB64)
+	}
+
+	_, err = base64.RawURLEncoding.DecodeString(pubB64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stapled %s: %w", stapledKeyPubB64, err)
+	}
+
+	return stapled, nil
+}
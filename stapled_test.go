@@ -0,0 +1,146 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testPubB64 is a valid (if meaningless) pubb64 value.
+const testPubB64 = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE"
+
+func TestStapledDataRoundTrip(t *testing.T) {
+	stapled := map[string]string{
+		stapledKeyPubB64: testPubB64,
+		stapledKeySigs:   "c2lnMQ== c2lnMg==",
+	}
+
+	suffix, err := encodeStapledData(stapled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseStapledData("Namecoin TLS Certificate" + suffix)
+	if err != nil {
+		t.Fatalf("parseStapledData: %v", err)
+	}
+
+	for k, v := range stapled {
+		if parsed[k] != v {
+			t.Errorf("stapled %s = %q, want %q", k, parsed[k], v)
+		}
+	}
+}
+
+func TestParseStapledData(t *testing.T) {
+	tests := []struct {
+		serial string
+		valid  bool
+	}{
+		{"Namecoin TLS Certificate", false},
+		{"Namecoin TLS Certificate" + stapledPrefix + `{"pubb64":"` + testPubB64 + `"}`, true},
+		{"Namecoin TLS Certificate" + stapledPrefix + `{"v":"1","pubb64":"` + testPubB64 + `"}`, true},
+		{"Namecoin TLS Certificate" + stapledPrefix + `{"pubb64":"` + testPubB64 + `","future":"x"}`, true},
+		{"Namecoin TLS Certificate" + stapledPrefix + `{"v":"2","pubb64":"` + testPubB64 + `"}`, false},
+		{"Namecoin TLS Certificate" + stapledPrefix + `{"sigs":"x"}`, false},
+		{"Namecoin TLS Certificate" + stapledPrefix + `{"pubb64":"not base64!"}`, false},
+		{"Namecoin TLS Certificate" + stapledPrefix + `{"pubb64":1}`, false},
+		{"Namecoin TLS Certificate" + stapledPrefix + `not json`, false},
+	}
+
+	for _, test := range tests {
+		_, err := parseStapledData(test.serial)
+		if test.valid && err != nil {
+			t.Errorf("parseStapledData(%q): %v", test.serial, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("parseStapledData(%q) succeeded; expected an error", test.serial)
+		}
+	}
+
+	_, err := parseStapledData("Namecoin TLS Certificate")
+	if !errors.Is(err, errNoStapledData) {
+		t.Errorf("parseStapledData without stapled data returned %v, want errNoStapledData", err)
+	}
+}
+
+// issueWithExtensions returns a self-signed cert with the given extensions.
+func issueWithExtensions(t *testing.T, exts []pkix.Extension) *x509.Certificate {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "stapled test"},
+		NotBefore:       time.Now(),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: exts,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestParseStapledExtension(t *testing.T) {
+	ext, err := encodeStapledExtension(map[string]string{stapledKeyPubB64: testPubB64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ext.Critical {
+		t.Error("stapled data extension is critical")
+	}
+
+	parsed, err := parseStapledExtension(issueWithExtensions(t, []pkix.Extension{ext}))
+	if err != nil {
+		t.Fatalf("parseStapledExtension: %v", err)
+	}
+	if parsed[stapledKeyPubB64] != testPubB64 {
+		t.Errorf("stapled %s = %q, want %q", stapledKeyPubB64, parsed[stapledKeyPubB64], testPubB64)
+	}
+
+	_, err = parseStapledExtension(issueWithExtensions(t, nil))
+	if !errors.Is(err, errNoStapledData) {
+		t.Errorf("parseStapledExtension without the extension returned %v, want errNoStapledData", err)
+	}
+
+	// An INTEGER instead of a UTF8String.
+	notString, err := asn1.Marshal(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trailing := append(append([]byte{}, ext.Value...), 0x05, 0x00)
+
+	for _, value := range [][]byte{notString, trailing} {
+		bad := pkix.Extension{Id: oidStapledData, Value: value}
+		_, err = parseStapledExtension(issueWithExtensions(t, []pkix.Extension{bad}))
+		if err == nil || errors.Is(err, errNoStapledData) {
+			t.Errorf("parseStapledExtension of malformed extension %x returned %v; expected a parse error", value, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// stubsigner is a minimal external signer for testing ncgencert's exec: and
+// unix: signer support.  It signs with an unencrypted PKCS#8 key file, e.g.:
+//
+//	go build -o stubsigner ./testdata/stubsigner
+//	ncgencert -host example.bit -parent-key "exec:./stubsigner caKey.pem"
+//
+// or, to serve on a Unix socket:
+//
+//	./stubsigner -listen /tmp/signer.sock caKey.pem &
+//	ncgencert -host example.bit -parent-key unix:/tmp/signer.sock
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+)
+
+var listen = flag.String("listen", "", "Unix socket path to serve on (default: serve a single session on stdin/stdout)")
+
+type request struct {
+	Op        string `json:"op"`
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+	Digest    []byte `json:"digest"`
+	Message   []byte `json:"message"`
+}
+
+type response struct {
+	PublicKey []byte `json:"public_key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+var hashes = map[string]crypto.Hash{
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+func handle(signer crypto.Signer, req *request) *response {
+	switch req.Op {
+	case "public-key":
+		pubBytes, err := x509.MarshalPKIXPublicKey(signer.Public())
+		if err != nil {
+			return &response{Error: err.Error()}
+		}
+
+		return &response{PublicKey: pubBytes}
+	case "sign":
+		var opts crypto.SignerOpts = crypto.Hash(0)
+		data := req.Message
+
+		if req.Hash != "" {
+			hash, ok := hashes[req.Hash]
+			if !ok {
+				return &response{Error: fmt.Sprintf("unsupported hash %q", req.Hash)}
+			}
+
+			opts = hash
+			data = req.Digest
+
+			if req.Algorithm == "RSA-PSS" {
+				opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+			}
+		}
+
+		sig, err := signer.Sign(rand.Reader, data, opts)
+		if err != nil {
+			return &response{Error: err.Error()}
+		}
+
+		return &response{Signature: sig}
+	default:
+		return &response{Error: fmt.Sprintf("unsupported op %q", req.Op)}
+	}
+}
+
+func serve(signer crypto.Signer, r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	encoder := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		req := &request{}
+
+		var resp *response
+		err := json.Unmarshal(scanner.Bytes(), req)
+		if err != nil {
+			resp = &response{Error: err.Error()}
+		} else {
+			resp = handle(signer, req)
+		}
+
+		err = encoder.Encode(resp)
+		if err != nil {
+			log.Printf("Failed to send response: %v", err)
+			return
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatalf("Usage: stubsigner [-listen SOCKET] KEYFILE")
+	}
+
+	privPEM, err := ioutil.ReadFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to read private key: %v", err)
+	}
+
+	privBlock, _ := pem.Decode(privPEM)
+	if privBlock == nil {
+		log.Fatalf("No PEM data in %s", flag.Arg(0))
+	}
+
+	priv, err := x509.ParsePKCS8PrivateKey(privBlock.Bytes)
+	if err != nil {
+		log.Fatalf("Failed to parse private key: %v", err)
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		log.Fatalf("Unsupported private key type %T", priv)
+	}
+
+	if *listen == "" {
+		serve(signer, os.Stdin, os.Stdout)
+		return
+	}
+
+	listener, err := net.Listen("unix", *listen)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Fatalf("Failed to accept: %v", err)
+		}
+
+		go func() {
+			defer conn.Close()
+			serve(signer, conn, conn)
+		}()
+	}
+}
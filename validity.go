@@ -0,0 +1,190 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// startDateLayouts are tried in order for -start-date values that aren't
+// RFC 3339, relative or Unix timestamps.  They carry no zone, so they're
+// interpreted in -time-zone.
+var startDateLayouts = []string{
+	"Jan 2 15:04:05 2006", // The original -start-date format.
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+const startDateFormats = "RFC 3339 (2006-01-02T15:04:05Z07:00), \"Jan 2 15:04:05 2006\", 2006-01-02[ 15:04[:05]], a Unix timestamp (@1136214245), \"now\", or a relative offset (-1h, +30m, -2d)"
+
+// parseStartDate parses a -start-date value.  Relative offsets are applied to
+// now.  Values without an explicit zone are interpreted in loc.
+func parseStartDate(value string, now time.Time, loc *time.Location) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	if value == "" || value == "now" {
+		return now, nil
+	}
+
+	if value[0] == '@' {
+		secs, err := strconv.ParseInt(value[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid Unix timestamp %q", value)
+		}
+
+		return time.Unix(secs, 0), nil
+	}
+
+	if value[0] == '+' || value[0] == '-' {
+		offset, err := parseOffset(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		return now.Add(offset), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+
+	for _, layout := range startDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+
+	// Bare numbers are ambiguous (20260102 looks like a date), so Unix
+	// timestamps must be written with @.
+	if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Time{}, fmt.Errorf("unrecognized date %q; Unix timestamps must be written as @%s", value, value)
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized date %q; expected %s", value, startDateFormats)
+}
+
+// parseOffset parses a signed Go duration, additionally accepting a whole
+// number of days such as "-2d".
+func parseOffset(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseInt(strings.TrimSuffix(value, "d"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid relative date %q", value)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid relative date %q: %w", value, err)
+	}
+
+	return offset, nil
+}
+
+// caDuration returns the validity period of the domain CA, or of the
+// -delegate CA.
+func caDuration() time.Duration {
+	if *caValidFor != 0 {
+		return *caValidFor
+	}
+
+	return *validFor
+}
+
+// aiaDuration returns the validity period of the AIA parent CA.
+func aiaDuration() time.Duration {
+	if *aiaValidFor != 0 {
+		return *aiaValidFor
+	}
+
+	return caDuration()
+}
+
+// caBackdateDuration returns how far to backdate the domain CA, or the
+// -delegate CA.
+func caBackdateDuration() time.Duration {
+	if *caBackdate != 0 {
+		return *caBackdate
+	}
+
+	return *backdate
+}
+
+// aiaBackdateDuration returns how far to backdate the AIA parent CA.
+func aiaBackdateDuration() time.Duration {
+	if *aiaBackdate != 0 {
+		return *aiaBackdate
+	}
+
+	return caBackdateDuration()
+}
+
+// floorTimestamp rounds t down to -timestamp-precision.  Every tier's
+// NotBefore and NotAfter are floored so that they don't reveal exactly when
+// the certs were generated.
+func floorTimestamp(t time.Time) time.Time {
+	precision := int64(*timestampPrecision / time.Second)
+
+	return time.Unix((t.Unix()/precision)*precision, 0)
+}
+
+// checkValidityWithin returns an error if child would outlive its issuer.
+// Clients reject chains where they find this, so it's better caught before
+// anything is written.
+func checkValidityWithin(child, issuer *x509.Certificate) error {
+	if child.NotAfter.After(issuer.NotAfter) {
+		return fmt.Errorf("%q would expire at %s, after its issuer %q expires at %s", child.Subject.CommonName, child.NotAfter.UTC().Format(time.RFC3339), issuer.Subject.CommonName, issuer.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+var cachedStartDate *time.Time
+
+// startDate returns the -start-date, parsed once so that every tier of the
+// hierarchy (and relative values like "-1h") agree on it.
+func startDate() time.Time {
+	if cachedStartDate != nil {
+		return *cachedStartDate
+	}
+
+	loc, err := time.LoadLocation(*timeZone)
+	if err != nil {
+		log.Fatalf("Invalid -time-zone: %v", err)
+	}
+
+	notBefore, err := parseStartDate(*validFrom, time.Now(), loc)
+	if err != nil {
+		log.Fatalf("Failed to parse creation date: %v", err)
+	}
+
+	// A random offset, shared by every tier, makes it harder to correlate
+	// the certs with a blockchain transaction made at about the same time.
+	// It's subtracted so that the certs are valid immediately.
+	if *timestampJitter > 0 {
+		jitter, err := rand.Int(rand.Reader, big.NewInt(int64(*timestampJitter)))
+		if err != nil {
+			log.Fatalf("Failed to generate timestamp jitter: %v", err)
+		}
+
+		notBefore = notBefore.Add(-time.Duration(jitter.Int64()))
+	}
+
+	cachedStartDate = &notBefore
+
+	return notBefore
+}
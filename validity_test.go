@@ -0,0 +1,73 @@
+// Copyright 2015-2026 Jeremy Rand. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStartDate(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	loc := time.FixedZone("UTC+2", 2*60*60)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"", now},
+		{"now", now},
+		{"  now  ", now},
+		{"@1136214245", time.Unix(1136214245, 0)},
+		{"@0", time.Unix(0, 0)},
+		{"-1h", now.Add(-time.Hour)},
+		{"+30m", now.Add(30 * time.Minute)},
+		{"-2d", now.Add(-48 * time.Hour)},
+		{"+1d", now.Add(24 * time.Hour)},
+		{"2006-01-02T15:04:05Z", time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04:05-07:00", time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC)},
+		{"2006-01-02T15:04:05.5Z", time.Date(2006, 1, 2, 15, 4, 5, 500000000, time.UTC)},
+		{"Jan 2 15:04:05 2006", time.Date(2006, 1, 2, 15, 4, 5, 0, loc)},
+		{"2006-01-02T15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, loc)},
+		{"2006-01-02 15:04:05", time.Date(2006, 1, 2, 15, 4, 5, 0, loc)},
+		{"2006-01-02 15:04", time.Date(2006, 1, 2, 15, 4, 0, 0, loc)},
+		{"2006-01-02", time.Date(2006, 1, 2, 0, 0, 0, 0, loc)},
+	}
+
+	for _, test := range tests {
+		got, err := parseStartDate(test.value, now, loc)
+		if err != nil {
+			t.Errorf("parseStartDate(%q): %v", test.value, err)
+			continue
+		}
+
+		if !got.Equal(test.want) {
+			t.Errorf("parseStartDate(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseStartDateInvalid(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	for _, value := range []string{
+		"tomorrow",
+		// Unix timestamps need the @ prefix.
+		"1136214245",
+		"20260102",
+		"@",
+		"@abc",
+		"-",
+		"+1y",
+		"-xd",
+		"2006-13-02",
+		"Jan 32 15:04:05 2006",
+	} {
+		_, err := parseStartDate(value, now, time.UTC)
+		if err == nil {
+			t.Errorf("parseStartDate(%q) succeeded; expected an error", value)
+		}
+	}
+}